@@ -1,12 +1,16 @@
 package main
 
 import (
+    "context"
     "fmt"
     "os"
+    "os/signal"
     "path/filepath"
+    "syscall"
 
     "github.com/zqtools/apicli/pkg/api"
     "github.com/zqtools/apicli/pkg/config"
+    "github.com/zqtools/apicli/pkg/term"
 )
 
 func main() {
@@ -32,9 +36,27 @@ func main() {
         os.Exit(1)
     }
 
+    // Cancel the running command on Ctrl-C or SIGTERM; a second signal exits at once
+    ctx, cancel := context.WithCancel(context.Background())
+    defer cancel()
+    signals := make(chan os.Signal, 2)
+    signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+    go func() {
+        <-signals
+        fmt.Fprintln(os.Stderr, "\nCancelling... press Ctrl-C again to exit immediately")
+        cancel()
+        <-signals
+        // Don't leave the terminal without echo if a hidden prompt is still reading
+        term.Restore()
+        os.Exit(130)
+    }()
+
     // Execute command
-    if err := cli.Execute(os.Args[1:]); err != nil {
+    if err := cli.Execute(ctx, os.Args[1:]); err != nil {
         fmt.Printf("Error executing command: %v\n", err)
+        if ctx.Err() != nil {
+            os.Exit(130)
+        }
         os.Exit(1)
     }
 }
@@ -1,15 +1,25 @@
 package api
 
 import (
+"context"
 "flag"
 "fmt"
+"io"
+"net/url"
+"os"
+"path/filepath"
 "strconv"
 "strings"
 
+"github.com/zqtools/apicli/pkg/auth"
 "github.com/zqtools/apicli/pkg/client"
 "github.com/zqtools/apicli/pkg/config"
+"github.com/zqtools/apicli/pkg/credential"
 "github.com/zqtools/apicli/pkg/history"
+"github.com/zqtools/apicli/pkg/redact"
+"github.com/zqtools/apicli/pkg/secrets"
 "github.com/zqtools/apicli/pkg/template"
+"github.com/zqtools/apicli/pkg/vars"
 )
 
 // CLI represents the command-line interface for the API client
@@ -17,51 +27,111 @@ type CLI struct {
 config    *config.Config
 verbose   *bool
 force     *bool
+env       string
+context   string
+stdinUsed bool
+configPath string
+timeout   string
+retries   *int
+apiDir    string
 userConfig *config.UserConfig
 history   *history.Manager
+vars      *vars.Store
+contexts  *config.Contexts
+tokens    *auth.TokenCache
+secrets   *secrets.Store
 }
 
 // NewCLI creates a new CLI instance
 func NewCLI(userConfig *config.UserConfig, apiDir string) (*CLI, error) {
-// Load API configuration
-apiConfig, err := config.LoadConfig(userConfig.APIConfigPath)
-if err != nil {
-return nil, fmt.Errorf("loading API config: %w", err)
-}
-
 // Initialize history manager
 historyManager, err := history.NewManager(apiDir)
 if err != nil {
 return nil, fmt.Errorf("initializing history manager: %w", err)
 }
 
+// Load the contexts stored next to the user configuration
+contexts, err := config.LoadContexts(filepath.Join(apiDir, "contexts"))
+if err != nil {
+return nil, err
+}
+
 // Create CLI instance
 cli := &CLI{
-config:     apiConfig,
+apiDir:     apiDir,
 verbose:    flag.Bool("verbose", false, "Show request details"),
 force:      flag.Bool("force", false, "Skip confirmation for non-GET requests"),
 userConfig: userConfig,
 history:    historyManager,
+vars:       vars.NewStore(apiDir),
+contexts:   contexts,
+tokens:     auth.NewTokenCache(filepath.Join(apiDir, "tokens")),
 }
+cli.secrets = secrets.NewStore(apiDir, cli.readPassphrase)
 
 return cli, nil
 }
 
+// loadConfig discovers and loads the API configuration on first use, so
+// commands that don't need it work outside of a project
+func (c *CLI) loadConfig() error {
+if c.config != nil {
+return nil
+}
+
+path, err := config.DiscoverConfig(c.configPath, c.userConfig, c.apiDir)
+if err != nil {
+return fmt.Errorf("locating API config: %w", err)
+}
+
+apiConfig, err := config.LoadConfig(path)
+if err != nil {
+return fmt.Errorf("loading API config: %w", err)
+}
+
+c.config = apiConfig
+return nil
+}
+
+// globalValueFlags lists the global flags that take a value
+var globalValueFlags = map[string]bool{
+"env":     true,
+"context": true,
+"config":  true,
+"timeout": true,
+"retries": true,
+}
+
+// findCommandStart returns the index of the first argument that is not a global flag
+func findCommandStart(args []string) int {
+for i := 0; i < len(args); i++ {
+arg := args[i]
+if !strings.HasPrefix(arg, "-") {
+return i
+}
+// Skip the value of flags given as "--name value"
+name := strings.TrimLeft(arg, "-")
+if !strings.Contains(name, "=") && globalValueFlags[name] {
+i++
+}
+}
+return len(args)
+}
+
 // Execute processes command line arguments and executes the API request
-func (c *CLI) Execute(args []string) error {
+func (c *CLI) Execute(ctx context.Context, args []string) error {
 // Create a new FlagSet for global flags
 globalFlags := flag.NewFlagSet("global", flag.ExitOnError)
 verbose := globalFlags.Bool("verbose", false, "Show request details")
 force := globalFlags.Bool("force", false, "Skip confirmation for non-GET requests")
+env := globalFlags.String("env", "", "Environment to run against")
+contextName := globalFlags.String("context", "", "Context to use instead of the active one")
+configPath := globalFlags.String("config", "", "Path to the API configuration file")
+timeout := globalFlags.String("timeout", "", "Request timeout, overriding the configuration")
+retries := globalFlags.String("retries", "", "Number of retries, overriding the configuration")
 
 // Find the position of the first non-flag argument
-cmdStart := 0
-for i, arg := range args {
-if !strings.HasPrefix(arg, "-") {
-cmdStart = i
-break
-}
-}
+cmdStart := findCommandStart(args)
 
 // Parse global flags
 if err := globalFlags.Parse(args[:cmdStart]); err != nil {
@@ -71,6 +141,18 @@ return fmt.Errorf("parsing global flags: %w", err)
 // Update CLI flags
 c.verbose = verbose
 c.force = force
+c.env = *env
+c.context = *contextName
+c.configPath = *configPath
+c.timeout = *timeout
+c.retries = nil
+if *retries != "" {
+n, err := strconv.Atoi(*retries)
+if err != nil || n < 0 {
+return fmt.Errorf("--retries must be a non-negative integer, not '%s'", *retries)
+}
+c.retries = &n
+}
 
 // Get remaining arguments
 remaining := args[cmdStart:]
@@ -82,21 +164,35 @@ return fmt.Errorf("no command specified")
 // Handle commands
 switch remaining[0] {
 case "call":
-return c.handleCallCommand(remaining[1:])
+return c.handleCallCommand(ctx, remaining[1:])
 case "history":
 return c.handleHistoryCommand(remaining[1:])
+case "config":
+return c.handleConfigCommand(remaining[1:])
+case "vars":
+return c.handleVarsCommand(remaining[1:])
+case "context":
+return c.handleContextCommand(remaining[1:])
+case "auth":
+return c.handleAuthCommand(remaining[1:])
+case "secret":
+return c.handleSecretCommand(ctx, remaining[1:])
 default:
 // For backward compatibility, treat as a call command
-return c.handleCallCommand(remaining)
+return c.handleCallCommand(ctx, remaining)
 }
 }
 
-func (c *CLI) handleCallCommand(args []string) error {
+func (c *CLI) handleCallCommand(ctx context.Context, args []string) error {
 if len(args) < 2 {
 c.printUsage()
 return fmt.Errorf("insufficient arguments for call command")
 }
 
+if err := c.loadConfig(); err != nil {
+return err
+}
+
 // Parse module path and API name
 modulePath := strings.Split(args[0], ".")
 apiName := args[1]
@@ -111,76 +207,358 @@ return fmt.Errorf("collecting module info: %w", err)
 apiFlags := flag.NewFlagSet("call", flag.ExitOnError)
 paramFlags := make(map[string]*string)
 
-// Define flags for all parameters
-for _, param := range moduleParams {
-paramFlags[param.Name] = apiFlags.String(param.Name, "", param.Description)
+// Define flags for all parameters; a name declared twice along the module
+// chain is ambiguous, as config validate reports
+for _, param := range append(append([]config.ParamDef{}, moduleParams...), apiSpec.Params...) {
+if _, ok := paramFlags[param.Name]; ok {
+return fmt.Errorf("parameter '%s' is declared more than once along %s.%s; run 'apicli config validate' for details", param.Name, args[0], apiName)
 }
-for _, param := range apiSpec.Params {
 paramFlags[param.Name] = apiFlags.String(param.Name, "", param.Description)
 }
 
+// Allow selecting the environment and context after the API name unless a parameter claims the name
+envName := c.env
+var envFlag *string
+if _, ok := paramFlags["env"]; !ok {
+envFlag = apiFlags.String("env", "", "Environment to run against")
+}
+contextName := c.context
+var contextFlag *string
+if _, ok := paramFlags["context"]; !ok {
+contextFlag = apiFlags.String("context", "", "Context to use instead of the active one")
+}
+
+apiFlags.Usage = func() {
+c.printAPIHelp(modulePath, apiName, moduleParams, apiSpec)
+}
+
+// Take --download out first unless a parameter claims the name
+callArgs := args[2:]
+var download *string
+if _, ok := paramFlags["download"]; !ok {
+callArgs, download = takeDownloadFlag(callArgs)
+}
+
 // Parse API-specific flags
-if err := apiFlags.Parse(args[2:]); err != nil {
+if err := apiFlags.Parse(callArgs); err != nil {
 return fmt.Errorf("parsing parameters: %w", err)
 }
 
-// Collect and validate parameter values
+// Resolve the context: call flag, global flag, then the active context
+if contextFlag != nil && *contextFlag != "" {
+contextName = *contextFlag
+}
+if contextName == "" {
+contextName = c.contexts.Current
+}
+var activeContext *config.Context
+if contextName != "" {
+activeContext, err = c.contexts.Get(contextName)
+if err != nil {
+return err
+}
+}
+
+// Resolve the environment: call flag, global flag, context, then user default
+if envFlag != nil && *envFlag != "" {
+envName = *envFlag
+}
+if envName == "" && activeContext != nil {
+envName = activeContext.Environment
+}
+if envName == "" {
+envName = c.userConfig.DefaultEnv
+}
+environment, err := c.config.GetEnvironment(envName)
+if err != nil {
+return err
+}
+var envVars map[string]string
+if environment != nil {
+envVars = environment.Variables
+}
+
+// Collect and validate parameter values, remembering secrets for masking
+redactor := redact.New(c.config.SensitiveHeaders)
 paramValues := make(map[string]interface{})
 allParams := append(moduleParams, apiSpec.Params...)
 for _, param := range allParams {
-value := *paramFlags[param.Name]
+contextValue, inContext := activeContext.Value(modulePath, param.Name)
+value, set, err := c.resolveParamValue(param, *paramFlags[param.Name], contextValue, inContext, envVars)
+if err != nil {
+return err
+}
 if err := c.validateParam(param, value); err != nil {
 return err
 }
-if value != "" {
-paramValues[param.Name] = c.convertParamValue(param.Type, value)
+if set {
+paramValues[param.Name] = c.convertParamValue(param, value)
+if param.Secret {
+redactor.AddValue(value)
+}
 }
 }
 
 // Merge request configurations
 mergedReq := config.MergeRequestConfigs(moduleReqs, &apiSpec.Request)
+if c.timeout != "" {
+mergedReq.Timeout = c.timeout
+}
+if c.retries != nil {
+mergedReq.Retries = c.retries
+}
+// Retries inherited from a module are meant for the methods that are safe
+// to resend, but asking for them on this API or call should not go unnoticed
+if mergedReq.RetriesIgnored() && (c.retries != nil || apiSpec.Request.Retries != nil) {
+fmt.Fprintf(os.Stderr, "Warning: not retrying: %s requests are only retried when retry_on is set\n", strings.ToUpper(mergedReq.Method))
+}
+if download != nil {
+// Without a path the file is named by the response in the current directory
+mergedReq.Download = *download
+if mergedReq.Download == "" {
+mergedReq.Download = "."
+}
+}
+templates := c.config.APITemplates(modulePath, apiName)
+
+// Fail before sending anything when a template needs a parameter that has no value
+if err := checkRequiredReferences(mergedReq, templates, allParams, paramValues, envVars); err != nil {
+return err
+}
+
+// Ask the credential helper before anything is sent, so its failures stop the call
+var credentials map[string]string
+if helper := c.config.CredentialHelper(modulePath); helper != "" {
+credentials, err = c.fetchCredentials(ctx, helper, modulePath, apiName, mergedReq, templates, paramValues, envVars)
+if err != nil {
+return err
+}
+for _, value := range credentials {
+redactor.AddValue(value)
+}
+}
 
 // Confirm non-GET requests unless forced
 if !*c.force && mergedReq.Method != "GET" {
-if confirmed := c.confirmRequest(mergedReq, paramValues); !confirmed {
+if confirmed := c.confirmRequest(ctx, mergedReq, templates, paramValues, contextName, envName, envVars, credentials, redactor); !confirmed {
 return fmt.Errorf("operation cancelled by user")
 }
 }
 
 // Create and execute request
 apiClient := client.NewClient(paramValues, *c.verbose, c.history, strings.Join(modulePath, "."), apiName)
-response, err := apiClient.ExecuteRequest(*mergedReq)
+apiClient.SetEnvironment(envName, envVars)
+apiClient.SetContext(contextName)
+apiClient.SetRedactor(redactor)
+apiClient.SetTemplates(templates)
+apiClient.SetVarStore(c.vars)
+apiClient.SetCaptures(apiSpec.Capture)
+apiClient.SetTokenCache(c.tokens)
+apiClient.SetCredentials(credentials)
+apiClient.SetFunc("secret", c.secretFunc(ctx))
+response, err := apiClient.ExecuteRequest(ctx, *mergedReq)
 if err != nil {
+if ctx.Err() != nil {
+return fmt.Errorf("request cancelled")
+}
 return fmt.Errorf("executing request: %w", err)
 }
 
+// Streamed responses were printed as they arrived
+if !apiClient.Streamed() {
 fmt.Println(response)
+}
 return nil
 }
 
+// takeDownloadFlag removes "--download [PATH]" from args. The flag package
+// cannot parse a flag whose value is optional, so the next argument is taken
+// as the path unless it is another flag.
+func takeDownloadFlag(args []string) ([]string, *string) {
+var rest []string
+var download *string
+for i := 0; i < len(args); i++ {
+name, value, hasValue := strings.Cut(strings.TrimLeft(args[i], "-"), "=")
+if !strings.HasPrefix(args[i], "-") || name != "download" {
+rest = append(rest, args[i])
+continue
+}
+if !hasValue && i+1 < len(args) && !strings.HasPrefix(args[i+1], "-") {
+i++
+value = args[i]
+}
+download = &value
+}
+return rest, download
+}
+
+// checkRequiredReferences reports the first declared parameter that a
+// template of the request needs but that resolved to no value
+func checkRequiredReferences(req *config.RequestSpec, templates *template.Set, params []config.ParamDef, values map[string]interface{}, envVars map[string]string) error {
+refs, err := req.RequiredReferences(templates)
+if err != nil {
+return err
+}
+
+declared := make(map[string]bool)
+for _, param := range params {
+declared[param.Name] = true
+}
+
+for _, ref := range refs {
+if !declared[ref.Name] {
+continue
+}
+if _, ok := values[ref.Name]; ok {
+continue
+}
+if _, ok := envVars[ref.Name]; ok {
+continue
+}
+if pos := ref.Pos.String(); pos != "" {
+return fmt.Errorf("%s: parameter '%s' is needed by this API but was not provided (--%s)", pos, ref.Name, ref.Name)
+}
+return fmt.Errorf("parameter '%s' is needed by this API but was not provided (--%s)", ref.Name, ref.Name)
+}
+return nil
+}
+
+// secretFunc implements ${secret("name")} with the CLI's secrets store; a
+// passphrase prompt it needs gives up when ctx is cancelled
+func (c *CLI) secretFunc(ctx context.Context) func(args []interface{}) (interface{}, error) {
+return func(args []interface{}) (interface{}, error) {
+return c.secrets.Get(ctx, fmt.Sprint(args[0]))
+}
+}
+
+// fetchCredentials runs a module's credential helper for the host the
+// request goes to
+func (c *CLI) fetchCredentials(ctx context.Context, helper string, modulePath []string, apiName string, req *config.RequestSpec, templates *template.Set, params map[string]interface{}, envVars map[string]string) (map[string]string, error) {
+renderer := template.NewRenderer(params)
+renderer.SetVariables(envVars)
+renderer.SetTemplates(templates)
+renderer.SetFunc("secret", c.secretFunc(ctx))
+if captured, err := c.vars.Values(); err == nil {
+renderer.SetCaptured(captured)
+}
+
+rendered, err := renderer.RenderURL(req.URL)
+if err != nil {
+return nil, fmt.Errorf("determining host for credential helper: %w", err)
+}
+target, err := url.Parse(rendered)
+if err != nil {
+return nil, fmt.Errorf("determining host for credential helper: %w", err)
+}
+
+credentials, err := credential.Get(ctx, helper, credential.Request{
+Module:   strings.Join(modulePath, "."),
+API:      apiName,
+Protocol: target.Scheme,
+Host:     target.Host,
+})
+if err != nil {
+if ctx.Err() != nil {
+return nil, fmt.Errorf("request cancelled")
+}
+return nil, fmt.Errorf("getting credentials for %s: %w", strings.Join(modulePath, "."), err)
+}
+return credentials, nil
+}
+
+// paramResolutionHelp describes the order in which parameter values are resolved
+const paramResolutionHelp = `Parameter values are resolved in order from:
+  1. the flag (--name value)
+  2. the environment variable named by the parameter's "env" setting
+  3. the active context (apicli context use NAME, or --context NAME)
+  4. the variable of the same name in the selected environment (--env)
+  5. the parameter's default
+A flag value of @path reads the value from a file and @- reads it from stdin;
+use @@ for a value that starts with a literal @.`
+
+// resolveParamValue determines a parameter's value from its flag, its
+// environment variable, the active context, the selected environment and
+// its default, in that order. The boolean reports whether a value was found
+// at all.
+func (c *CLI) resolveParamValue(param config.ParamDef, flagValue, contextValue string, inContext bool, envVars map[string]string) (string, bool, error) {
+if flagValue != "" {
+value, err := c.readFlagValue(flagValue)
+if err != nil {
+return "", false, fmt.Errorf("parameter '%s': %w", param.Name, err)
+}
+return value, true, nil
+}
+if param.Env != "" {
+if envValue, ok := os.LookupEnv(param.Env); ok && envValue != "" {
+return envValue, true, nil
+}
+}
+if inContext {
+return contextValue, true, nil
+}
+if envValue, ok := envVars[param.Name]; ok {
+return envValue, true, nil
+}
+if param.Default != nil {
+return *param.Default, true, nil
+}
+return "", false, nil
+}
+
+// readFlagValue expands @path and @- flag values to the contents of the file
+// or stdin. A single trailing newline is dropped so token files work as-is.
+func (c *CLI) readFlagValue(value string) (string, error) {
+switch {
+case !strings.HasPrefix(value, "@"):
+return value, nil
+case strings.HasPrefix(value, "@@"):
+return value[1:], nil
+case value == "@-":
+if c.stdinUsed {
+return "", fmt.Errorf("stdin can only be read by one parameter")
+}
+c.stdinUsed = true
+data, err := io.ReadAll(os.Stdin)
+if err != nil {
+return "", fmt.Errorf("reading stdin: %w", err)
+}
+return trimTrailingNewline(string(data)), nil
+default:
+data, err := os.ReadFile(value[1:])
+if err != nil {
+return "", fmt.Errorf("reading value file: %w", err)
+}
+return trimTrailingNewline(string(data)), nil
+}
+}
+
+func trimTrailingNewline(value string) string {
+value = strings.TrimSuffix(value, "\n")
+return strings.TrimSuffix(value, "\r")
+}
+
 func (c *CLI) validateParam(param config.ParamDef, value string) error {
 if param.Required && value == "" {
 return fmt.Errorf("parameter '%s' is required", param.Name)
 }
 if value != "" {
-if err := template.ValidateType(value, param.Type); err != nil {
+if _, err := param.Parse(value); err != nil {
+return fmt.Errorf("parameter '%s': %w", param.Name, err)
+}
+if err := param.CheckValue(value); err != nil {
 return fmt.Errorf("parameter '%s': %w", param.Name, err)
 }
 }
 return nil
 }
 
-func (c *CLI) convertParamValue(paramType, value string) interface{} {
-switch {
-case paramType == "integer":
+func (c *CLI) convertParamValue(param config.ParamDef, value string) interface{} {
 // Already validated, so conversion should succeed
-val, _ := strconv.Atoi(value)
-return val
-case paramType == "boolean":
-return value == "true"
-default:
+val, err := param.Parse(value)
+if err != nil {
 return value
 }
+return val
 }
 
 func (c *CLI) handleHistoryCommand(args []string) error {
@@ -201,6 +579,52 @@ return fmt.Errorf("unknown history subcommand: %s", args[0])
 }
 }
 
+func (c *CLI) handleConfigCommand(args []string) error {
+if len(args) == 0 {
+c.printUsage()
+return fmt.Errorf("no config subcommand specified")
+}
+
+switch args[0] {
+case "validate":
+return c.handleConfigValidate(args[1:])
+default:
+return fmt.Errorf("unknown config subcommand: %s", args[0])
+}
+}
+
+func (c *CLI) handleConfigValidate(args []string) error {
+validateFlags := flag.NewFlagSet("config validate", flag.ExitOnError)
+if err := validateFlags.Parse(args); err != nil {
+return err
+}
+
+// An explicit file argument takes precedence over discovery
+path := validateFlags.Arg(0)
+if path == "" {
+discovered, err := config.DiscoverConfig(c.configPath, c.userConfig, c.apiDir)
+if err != nil {
+return fmt.Errorf("locating API config: %w", err)
+}
+path = discovered
+}
+
+issues, err := config.Validate(path)
+if err != nil {
+return fmt.Errorf("validating %s: %w", path, err)
+}
+
+if len(issues) == 0 {
+fmt.Printf("%s: OK\n", path)
+return nil
+}
+
+for _, issue := range issues {
+fmt.Println(issue.String())
+}
+return fmt.Errorf("found %d problem(s) in %s", len(issues), path)
+}
+
 func (c *CLI) handleHistoryList(args []string) error {
 listFlags := flag.NewFlagSet("history list", flag.ExitOnError)
 limit := listFlags.Int("limit", 10, "Maximum number of entries to show")
@@ -222,8 +646,21 @@ fmt.Printf("Last %d API calls:\n\n", len(entries))
 for _, entry := range entries {
 fmt.Printf("Time: %s\n", entry.Timestamp.Format("2006-01-02 15:04:05"))
 fmt.Printf("Command: apicli %s\n", entry.GetCommandLine())
+if entry.Context != "" {
+fmt.Printf("Context: %s\n", entry.Context)
+}
+if entry.Environment != "" {
+fmt.Printf("Environment: %s\n", entry.Environment)
+}
 fmt.Printf("ID: %s\n", entry.ID)
+if entry.State != "" {
+fmt.Printf("Status: %s\n", entry.State)
+} else {
 fmt.Printf("Status: %d\n", entry.Response.StatusCode)
+}
+if entry.Attempts > 1 {
+fmt.Printf("Attempts: %d\n", entry.Attempts)
+}
 fmt.Println(strings.Repeat("-", 80))
 }
 
@@ -244,6 +681,15 @@ fmt.Printf("API Call Details:\n")
 fmt.Printf("Time: %s\n", entry.Timestamp.Format("2006-01-02 15:04:05"))
 fmt.Printf("Module: %s\n", entry.Module)
 fmt.Printf("API: %s\n", entry.API)
+if entry.Context != "" {
+fmt.Printf("Context: %s\n", entry.Context)
+}
+if entry.Environment != "" {
+fmt.Printf("Environment: %s\n", entry.Environment)
+}
+if entry.Attempts > 1 {
+fmt.Printf("Attempts: %d\n", entry.Attempts)
+}
 fmt.Printf("\nRequest:\n")
 fmt.Printf("Method: %s\n", entry.Request.Method)
 fmt.Printf("URL: %s\n", entry.Request.URL)
@@ -270,14 +716,30 @@ fmt.Printf("  %s: %s\n", k, v)
 }
 
 fmt.Printf("\nResponse:\n")
+if entry.State != "" && entry.Response.StatusCode == 0 {
+fmt.Printf("Status: %s before a response was received\n", entry.State)
+return nil
+}
 fmt.Printf("Status: %d\n", entry.Response.StatusCode)
+if entry.State != "" {
+fmt.Printf("State: %s while the response was read\n", entry.State)
+}
 if len(entry.Response.Headers) > 0 {
 fmt.Printf("\nHeaders:\n")
 for k, v := range entry.Response.Headers {
 fmt.Printf("  %s: %s\n", k, v)
 }
 }
+switch {
+case entry.Response.File != "":
+fmt.Printf("\nSaved to: %s (%d bytes)\n", entry.Response.File, entry.Response.Size)
+case entry.Response.Events > 0:
+fmt.Printf("\nBody (streamed, %d events):\n%s\n", entry.Response.Events, entry.Response.Body)
+case entry.Response.Streamed:
+fmt.Printf("\nBody (streamed):\n%s\n", entry.Response.Body)
+default:
 fmt.Printf("\nBody:\n%s\n", entry.Response.Body)
+}
 
 return nil
 }
@@ -299,30 +761,76 @@ fmt.Println("History cleared successfully")
 return nil
 }
 
-func (c *CLI) confirmRequest(req *config.RequestSpec, params map[string]interface{}) bool {
-fmt.Printf("\nAbout to send %s request to %s\n", req.Method, req.URL)
-
+func (c *CLI) confirmRequest(ctx context.Context, req *config.RequestSpec, templates *template.Set, params map[string]interface{}, contextName, envName string, envVars, credentials map[string]string, redactor *redact.Redactor) bool {
 renderer := template.NewRenderer(params)
+renderer.SetVariables(envVars)
+renderer.SetTemplates(templates)
+renderer.SetCredentials(credentials)
+renderer.SetMasker(redactor.AddValue)
+renderer.SetFunc("secret", c.secretFunc(ctx))
+if captured, err := c.vars.Values(); err == nil {
+renderer.SetCaptured(captured)
+}
+
+url := req.URL
+if rendered, err := renderer.RenderURL(req.URL); err == nil {
+url = rendered
+}
+fmt.Printf("\nAbout to send %s request to %s\n", req.Method, redactor.String(url))
+if contextName != "" {
+fmt.Printf("Context: %s\n", contextName)
+}
+if envName != "" {
+fmt.Printf("Environment: %s\n", envName)
+}
 
-if req.Body != "" {
-body, err := renderer.Render(req.Body)
+if req.HasJSONBody() {
+body, err := renderer.RenderJSONTree(&req.JSON)
 if err == nil {
-fmt.Printf("With body:\n%s\n", body)
+fmt.Printf("With body:\n%s\n", redactor.String(body))
+}
+} else if req.Body != "" {
+render := renderer.Render
+if req.IsJSONBody() {
+render = renderer.RenderJSON
+}
+body, err := render(req.Body)
+if err == nil {
+fmt.Printf("With body:\n%s\n", redactor.String(body))
 }
 } else if len(req.Form) > 0 {
 fmt.Println("With form data:")
-for k, v := range req.Form {
-if val, err := renderer.Render(v); err == nil {
-fmt.Printf("  %s: %s\n", k, val)
+for k, field := range req.Form {
+if len(field.Files) == 0 {
+if val, err := renderer.Render(field.Value); err == nil {
+fmt.Printf("  %s: %s\n", k, redactor.String(val))
+}
+continue
+}
+for _, file := range field.Files {
+if path, err := renderer.Render(file); err == nil {
+fmt.Printf("  %s: @%s\n", k, path)
+}
 }
 }
 }
 
 fmt.Print("\nDo you want to proceed? [y/N] ")
 
+// Reading stdin cannot be interrupted, so wait for the answer or cancellation
+answers := make(chan string, 1)
+go func() {
 var answer string
 fmt.Scanln(&answer)
+answers <- answer
+}()
+select {
+case answer := <-answers:
 return strings.ToLower(answer) == "y"
+case <-ctx.Done():
+fmt.Println()
+return false
+}
 }
 
 func (c *CLI) printUsage() {
@@ -332,9 +840,38 @@ fmt.Println("  call MODULE[.SUBMODULE] API [parameters]  Call an API endpoint")
 fmt.Println("  history list [--limit N]                  List recent API calls")
 fmt.Println("  history show ID                          Show details of a specific API call")
 fmt.Println("  history clear                            Clear API call history")
+fmt.Println("  config validate [FILE]                   Check the API configuration for mistakes")
+fmt.Println("  vars list                                List variables captured from responses")
+fmt.Println("  vars clear [NAME...]                     Remove captured variables")
+fmt.Println("  context list|show|create|set|use|delete   Manage saved parameter values (see context help)")
+fmt.Println("  auth status                              Show cached OAuth2 tokens")
+fmt.Println("  auth logout                              Remove cached OAuth2 tokens")
+fmt.Println("  secret set|get|list|rm                   Manage the encrypted secrets store")
 fmt.Println("\nOptions:")
 fmt.Println("  --verbose\tShow request details")
 fmt.Println("  --force\tSkip confirmation for non-GET requests")
+fmt.Println("  --env NAME\tRun against the named environment")
+fmt.Println("  --context NAME\tUse the named context instead of the active one")
+fmt.Println("  --config PATH\tUse the given API configuration instead of discovering one")
+fmt.Println("  --timeout DURATION\tRequest timeout, overriding the configuration (default 30s)")
+fmt.Println("  --retries N\tRetry failed requests N times, overriding the configuration")
+fmt.Println("  --download [PATH]\tSave the response to PATH or the current directory (after the API name)")
+
+// Show available modules when a configuration can be found
+if c.config == nil {
+c.loadConfig()
+}
+
+if c.config != nil && len(c.config.Environments) > 0 {
+fmt.Println("\nAvailable environments:")
+for name, env := range c.config.Environments {
+marker := ""
+if name == c.userConfig.DefaultEnv {
+marker = " (default)"
+}
+fmt.Printf("  %s\t%s%s\n", name, env.Description, marker)
+}
+}
 
 if c.config != nil {
 fmt.Println("\nAvailable modules:")
@@ -349,12 +886,12 @@ fmt.Printf("%s%s%s\t%s\n", indent, prefix, name, module.Description)
 if len(module.Params) > 0 {
 fmt.Printf("%s  Module Parameters:\n", indent)
 for _, param := range module.Params {
-required := ""
-if param.Required {
-required = " (required)"
+c.printParam(indent+"    ", param)
 }
-fmt.Printf("%s    --%s\t%s [%s]%s\n", indent, param.Name, param.Description, param.Type, required)
 }
+
+if module.Request != nil && module.Request.BaseURL != "" {
+fmt.Printf("%s  Module Base URL: %s\n", indent, module.Request.BaseURL)
 }
 
 if module.Request != nil && len(module.Request.Headers) > 0 {
@@ -381,11 +918,7 @@ fmt.Printf("%s    %s (%s %s)\n", indent, apiName, api.Request.Method, api.Reques
 if len(api.Params) > 0 {
 fmt.Printf("%s      Parameters:\n", indent)
 for _, param := range api.Params {
-required := ""
-if param.Required {
-required = " (required)"
-}
-fmt.Printf("%s        --%s\t%s [%s]%s\n", indent, param.Name, param.Description, param.Type, required)
+c.printParam(indent+"        ", param)
 }
 }
 }
@@ -393,3 +926,49 @@ fmt.Println()
 }
 }
 }
+
+// printAPIHelp prints the usage of a single API, including where each
+// parameter value may come from
+func (c *CLI) printAPIHelp(modulePath []string, apiName string, moduleParams []config.ParamDef, apiSpec *config.APISpec) {
+fmt.Printf("Usage: apicli call %s %s [parameters] [--download [PATH]]\n", strings.Join(modulePath, "."), apiName)
+fmt.Printf("\n%s %s\n", apiSpec.Request.Method, apiSpec.Request.URL)
+
+if len(moduleParams) > 0 {
+fmt.Println("\nModule Parameters:")
+for _, param := range moduleParams {
+c.printParam("  ", param)
+}
+}
+if len(apiSpec.Params) > 0 {
+fmt.Println("\nParameters:")
+for _, param := range apiSpec.Params {
+c.printParam("  ", param)
+}
+}
+if len(apiSpec.Capture) > 0 {
+fmt.Println("\nCaptures:")
+for _, name := range sortedKeys(apiSpec.Capture) {
+capture := apiSpec.Capture[name]
+ttl := ""
+if capture.TTL != "" {
+ttl = " (ttl " + capture.TTL + ")"
+}
+fmt.Printf("  vars.%s\t%s%s\n", name, capture.Path, ttl)
+}
+}
+
+fmt.Printf("\n%s\n", paramResolutionHelp)
+}
+
+// printParam prints a single parameter line with its type, constraints and default
+func (c *CLI) printParam(indent string, param config.ParamDef) {
+required := ""
+if param.Required {
+required = " (required)"
+}
+constraints := ""
+if summary := param.Constraints(); summary != "" {
+constraints = " (" + summary + ")"
+}
+fmt.Printf("%s--%s\t%s [%s]%s%s\n", indent, param.Name, param.Description, template.DescribeType(param.Type, param.Format), required, constraints)
+}
@@ -0,0 +1,79 @@
+package api
+
+import (
+"flag"
+"fmt"
+"strings"
+"time"
+)
+
+func (c *CLI) handleAuthCommand(args []string) error {
+if len(args) == 0 {
+c.printUsage()
+return fmt.Errorf("no auth subcommand specified")
+}
+
+switch args[0] {
+case "status":
+return c.handleAuthStatus(args[1:])
+case "logout":
+return c.handleAuthLogout(args[1:])
+default:
+return fmt.Errorf("unknown auth subcommand: %s", args[0])
+}
+}
+
+func (c *CLI) handleAuthStatus(args []string) error {
+statusFlags := flag.NewFlagSet("auth status", flag.ExitOnError)
+if err := statusFlags.Parse(args); err != nil {
+return err
+}
+
+tokens, err := c.tokens.List()
+if err != nil {
+return err
+}
+if len(tokens) == 0 {
+fmt.Println("No cached tokens")
+return nil
+}
+
+now := time.Now()
+for _, token := range tokens {
+fmt.Printf("%s (client %s)\n", token.TokenURL, token.ClientID)
+if len(token.Scopes) > 0 {
+fmt.Printf("  Scopes: %s\n", strings.Join(token.Scopes, " "))
+}
+if !token.ObtainedAt.IsZero() {
+fmt.Printf("  Obtained: %s\n", token.ObtainedAt.Format("2006-01-02 15:04:05"))
+}
+switch {
+case token.ExpiresAt.IsZero():
+fmt.Println("  Expires: never")
+case token.ExpiresAt.After(now):
+fmt.Printf("  Expires: %s (in %s)\n", token.ExpiresAt.Format("2006-01-02 15:04:05"), token.ExpiresAt.Sub(now).Round(time.Second))
+default:
+fmt.Printf("  Expires: %s (expired)\n", token.ExpiresAt.Format("2006-01-02 15:04:05"))
+}
+if token.RefreshToken != "" {
+fmt.Println("  Refresh token: yes")
+} else {
+fmt.Println("  Refresh token: no")
+}
+}
+return nil
+}
+
+func (c *CLI) handleAuthLogout(args []string) error {
+logoutFlags := flag.NewFlagSet("auth logout", flag.ExitOnError)
+if err := logoutFlags.Parse(args); err != nil {
+return err
+}
+
+removed, err := c.tokens.Clear()
+if err != nil {
+return err
+}
+fmt.Printf("Removed %d cached token(s)\n", removed)
+return nil
+}
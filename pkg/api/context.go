@@ -0,0 +1,298 @@
+package api
+
+import (
+"flag"
+"fmt"
+"sort"
+"strings"
+
+"github.com/zqtools/apicli/pkg/config"
+"github.com/zqtools/apicli/pkg/redact"
+)
+
+// contextHelp describes the context subcommands
+const contextHelp = `Usage: apicli context SUBCOMMAND
+
+Contexts save parameter values (and optionally an environment) that fill in
+parameters not given as flags while the context is active.
+
+  context list                                   List contexts, marking the active one
+  context show [NAME]                            Show a context (default: the active one)
+  context create NAME [--env ENV] [--set K=V]... Create a context
+  context set NAME [--env ENV] [--set K=V]... [--unset K]...
+                                                 Change a context
+  context use NAME                               Make a context active
+  context use --none                             Deactivate the active context
+  context delete NAME                            Delete a context
+
+Keys are parameter names, optionally prefixed with a module path
+("user.token") to apply only to APIs below that module.`
+
+// assignmentsFlag collects repeated key=value flags
+type assignmentsFlag []string
+
+func (a *assignmentsFlag) String() string {
+return strings.Join(*a, ",")
+}
+
+func (a *assignmentsFlag) Set(value string) error {
+if _, _, err := config.ParseAssignment(value); err != nil {
+return err
+}
+*a = append(*a, value)
+return nil
+}
+
+// listFlag collects repeated flags
+type listFlag []string
+
+func (l *listFlag) String() string {
+return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(value string) error {
+*l = append(*l, value)
+return nil
+}
+
+func (c *CLI) handleContextCommand(args []string) error {
+if len(args) == 0 || args[0] == "help" {
+fmt.Println(contextHelp)
+if len(args) == 0 {
+return fmt.Errorf("no context subcommand specified")
+}
+return nil
+}
+
+switch args[0] {
+case "list":
+return c.handleContextList()
+case "show":
+return c.handleContextShow(args[1:])
+case "create":
+return c.handleContextEdit(args[1:], true)
+case "set":
+return c.handleContextEdit(args[1:], false)
+case "use":
+return c.handleContextUse(args[1:])
+case "delete":
+return c.handleContextDelete(args[1:])
+default:
+return fmt.Errorf("unknown context subcommand: %s", args[0])
+}
+}
+
+func (c *CLI) handleContextList() error {
+names := c.contexts.Names()
+if len(names) == 0 {
+fmt.Println("No contexts defined")
+return nil
+}
+
+for _, name := range names {
+marker := " "
+if name == c.contexts.Current {
+marker = "*"
+}
+context := c.contexts.Contexts[name]
+env := ""
+if context.Environment != "" {
+env = " (env: " + context.Environment + ")"
+}
+fmt.Printf("%s %s%s\n", marker, name, env)
+}
+return nil
+}
+
+func (c *CLI) handleContextShow(args []string) error {
+name := c.contexts.Current
+if len(args) > 0 {
+name = args[0]
+}
+if name == "" {
+return fmt.Errorf("no context is active; name one to show")
+}
+
+context, err := c.contexts.Get(name)
+if err != nil {
+return err
+}
+
+fmt.Printf("Context: %s\n", name)
+if name == c.contexts.Current {
+fmt.Println("Active: yes")
+}
+if context.Environment != "" {
+fmt.Printf("Environment: %s\n", context.Environment)
+}
+if len(context.Values) > 0 {
+keys := make([]string, 0, len(context.Values))
+for key := range context.Values {
+keys = append(keys, key)
+}
+sort.Strings(keys)
+
+secrets := c.secretParamNames()
+fmt.Println("Values:")
+for _, key := range keys {
+value := context.Values[key]
+if secrets[key[strings.LastIndex(key, ".")+1:]] {
+value = redact.Mask
+}
+fmt.Printf("  %s=%s\n", key, value)
+}
+}
+return nil
+}
+
+// handleContextEdit creates a context or changes an existing one
+func (c *CLI) handleContextEdit(args []string, create bool) error {
+if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+return fmt.Errorf("no context name specified")
+}
+name := args[0]
+
+editFlags := flag.NewFlagSet("context", flag.ExitOnError)
+env := editFlags.String("env", "", "Environment to select while the context is active")
+var set assignmentsFlag
+editFlags.Var(&set, "set", "Set a parameter value (key=value, repeatable)")
+var unset listFlag
+if !create {
+editFlags.Var(&unset, "unset", "Remove a parameter value (repeatable)")
+}
+use := false
+if create {
+editFlags.BoolVar(&use, "use", false, "Make the new context active")
+}
+if err := editFlags.Parse(args[1:]); err != nil {
+return err
+}
+
+_, exists := c.contexts.Contexts[name]
+switch {
+case create && exists:
+return fmt.Errorf("context '%s' already exists; use 'context set' to change it", name)
+case !create && !exists:
+if _, err := c.contexts.Get(name); err != nil {
+return err
+}
+}
+
+context := c.contexts.Contexts[name]
+if context.Values == nil {
+context.Values = make(map[string]string)
+}
+if *env != "" {
+context.Environment = *env
+}
+for _, assignment := range set {
+key, value, _ := config.ParseAssignment(assignment)
+context.Values[key] = value
+}
+for _, key := range unset {
+if _, ok := context.Values[key]; !ok {
+return fmt.Errorf("context '%s' has no value for '%s'", name, key)
+}
+delete(context.Values, key)
+}
+c.contexts.Contexts[name] = context
+if use {
+c.contexts.Current = name
+}
+
+if err := c.contexts.Save(); err != nil {
+return err
+}
+
+if create {
+fmt.Printf("Context '%s' created\n", name)
+} else {
+fmt.Printf("Context '%s' updated\n", name)
+}
+if use {
+fmt.Printf("Switched to context '%s'\n", name)
+}
+return nil
+}
+
+func (c *CLI) handleContextUse(args []string) error {
+useFlags := flag.NewFlagSet("context use", flag.ExitOnError)
+none := useFlags.Bool("none", false, "Deactivate the active context")
+if err := useFlags.Parse(args); err != nil {
+return err
+}
+
+if *none {
+c.contexts.Current = ""
+if err := c.contexts.Save(); err != nil {
+return err
+}
+fmt.Println("No context is active")
+return nil
+}
+
+name := useFlags.Arg(0)
+if name == "" {
+return fmt.Errorf("no context name specified")
+}
+if _, err := c.contexts.Get(name); err != nil {
+return err
+}
+
+c.contexts.Current = name
+if err := c.contexts.Save(); err != nil {
+return err
+}
+fmt.Printf("Switched to context '%s'\n", name)
+return nil
+}
+
+func (c *CLI) handleContextDelete(args []string) error {
+if len(args) == 0 {
+return fmt.Errorf("no context name specified")
+}
+name := args[0]
+if _, err := c.contexts.Get(name); err != nil {
+return err
+}
+
+delete(c.contexts.Contexts, name)
+if c.contexts.Current == name {
+c.contexts.Current = ""
+}
+if err := c.contexts.Save(); err != nil {
+return err
+}
+fmt.Printf("Context '%s' deleted\n", name)
+return nil
+}
+
+// secretParamNames returns the names of parameters declared secret anywhere
+// in the API configuration, when one can be loaded
+func (c *CLI) secretParamNames() map[string]bool {
+secrets := make(map[string]bool)
+if err := c.loadConfig(); err != nil {
+return secrets
+}
+
+var walk func(modules map[string]config.Module)
+walk = func(modules map[string]config.Module) {
+for _, module := range modules {
+for _, param := range module.Params {
+if param.Secret {
+secrets[param.Name] = true
+}
+}
+for _, api := range module.APIs {
+for _, param := range api.Params {
+if param.Secret {
+secrets[param.Name] = true
+}
+}
+}
+walk(module.Modules)
+}
+}
+walk(c.config.Modules)
+return secrets
+}
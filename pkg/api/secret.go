@@ -0,0 +1,162 @@
+package api
+
+import (
+"context"
+"flag"
+"fmt"
+"io"
+"os"
+"sort"
+"strings"
+
+"github.com/zqtools/apicli/pkg/secrets"
+"github.com/zqtools/apicli/pkg/term"
+)
+
+func (c *CLI) handleSecretCommand(ctx context.Context, args []string) error {
+if len(args) == 0 {
+c.printUsage()
+return fmt.Errorf("no secret subcommand specified")
+}
+
+switch args[0] {
+case "set":
+return c.handleSecretSet(ctx, args[1:])
+case "get":
+return c.handleSecretGet(ctx, args[1:])
+case "list":
+return c.handleSecretList(ctx, args[1:])
+case "rm":
+return c.handleSecretRemove(ctx, args[1:])
+default:
+return fmt.Errorf("unknown secret subcommand: %s", args[0])
+}
+}
+
+// handleSecretSet stores a secret. The value is read from stdin when it is
+// redirected and prompted for otherwise, so it never appears in shell history.
+func (c *CLI) handleSecretSet(ctx context.Context, args []string) error {
+setFlags := flag.NewFlagSet("secret set", flag.ExitOnError)
+if err := setFlags.Parse(args); err != nil {
+return err
+}
+if setFlags.NArg() != 1 {
+return fmt.Errorf("usage: apicli secret set NAME (the value is read from stdin or prompted for)")
+}
+name := setFlags.Arg(0)
+
+// Unlock the store first, so a wrong passphrase fails before the value is typed
+if err := c.secrets.Unlock(ctx); err != nil {
+return err
+}
+
+var value string
+if term.IsTerminal(os.Stdin) {
+tty, err := term.Open()
+if err != nil {
+return err
+}
+defer tty.Close()
+if value, err = term.ReadHidden(ctx, tty, fmt.Sprintf("Value for %s: ", name)); err != nil {
+return err
+}
+} else {
+data, err := io.ReadAll(os.Stdin)
+if err != nil {
+return fmt.Errorf("reading secret from stdin: %w", err)
+}
+value = strings.TrimRight(string(data), "\r\n")
+}
+if value == "" {
+return fmt.Errorf("refusing to store an empty secret")
+}
+
+if err := c.secrets.Set(ctx, name, value); err != nil {
+return err
+}
+fmt.Printf("Secret '%s' saved\n", name)
+return nil
+}
+
+func (c *CLI) handleSecretGet(ctx context.Context, args []string) error {
+if len(args) != 1 {
+return fmt.Errorf("usage: apicli secret get NAME")
+}
+value, err := c.secrets.Get(ctx, args[0])
+if err != nil {
+return err
+}
+fmt.Println(value)
+return nil
+}
+
+func (c *CLI) handleSecretList(ctx context.Context, args []string) error {
+listFlags := flag.NewFlagSet("secret list", flag.ExitOnError)
+if err := listFlags.Parse(args); err != nil {
+return err
+}
+
+stored, err := c.secrets.List(ctx)
+if err != nil {
+return err
+}
+if len(stored) == 0 {
+fmt.Println("No secrets stored")
+return nil
+}
+
+names := make([]string, 0, len(stored))
+for name := range stored {
+names = append(names, name)
+}
+sort.Strings(names)
+for _, name := range names {
+fmt.Printf("%s\t(updated %s)\n", name, stored[name].UpdatedAt.Format("2006-01-02 15:04:05"))
+}
+return nil
+}
+
+func (c *CLI) handleSecretRemove(ctx context.Context, args []string) error {
+if len(args) == 0 {
+return fmt.Errorf("usage: apicli secret rm NAME...")
+}
+missing, err := c.secrets.Delete(ctx, args...)
+if err != nil {
+return err
+}
+if len(missing) > 0 {
+return fmt.Errorf("no secret named: %s", strings.Join(missing, ", "))
+}
+fmt.Printf("Removed %d secret(s)\n", len(args))
+return nil
+}
+
+// readPassphrase supplies the secrets passphrase from the environment, or
+// prompts for it on the terminal. A new store's passphrase is asked twice.
+func (c *CLI) readPassphrase(ctx context.Context, create bool) (string, error) {
+if passphrase, ok := os.LookupEnv(secrets.PassphraseEnv); ok {
+return passphrase, nil
+}
+
+tty, err := term.Open()
+if err != nil {
+return "", fmt.Errorf("the secrets store needs a passphrase: set %s or run apicli from a terminal", secrets.PassphraseEnv)
+}
+defer tty.Close()
+
+if !create {
+return term.ReadHidden(ctx, tty, "Secrets passphrase: ")
+}
+passphrase, err := term.ReadHidden(ctx, tty, "New secrets passphrase: ")
+if err != nil {
+return "", err
+}
+repeated, err := term.ReadHidden(ctx, tty, "Repeat passphrase: ")
+if err != nil {
+return "", err
+}
+if passphrase != repeated {
+return "", fmt.Errorf("passphrases do not match")
+}
+return passphrase, nil
+}
@@ -0,0 +1,96 @@
+package api
+
+import (
+"flag"
+"fmt"
+"sort"
+"strings"
+"time"
+
+"github.com/zqtools/apicli/pkg/config"
+"github.com/zqtools/apicli/pkg/redact"
+"github.com/zqtools/apicli/pkg/template"
+)
+
+func (c *CLI) handleVarsCommand(args []string) error {
+if len(args) == 0 {
+c.printUsage()
+return fmt.Errorf("no vars subcommand specified")
+}
+
+switch args[0] {
+case "list":
+return c.handleVarsList(args[1:])
+case "clear":
+return c.handleVarsClear(args[1:])
+default:
+return fmt.Errorf("unknown vars subcommand: %s", args[0])
+}
+}
+
+func (c *CLI) handleVarsList(args []string) error {
+listFlags := flag.NewFlagSet("vars list", flag.ExitOnError)
+if err := listFlags.Parse(args); err != nil {
+return err
+}
+
+variables, err := c.vars.Load()
+if err != nil {
+return fmt.Errorf("loading variables: %w", err)
+}
+if len(variables) == 0 {
+fmt.Println("No captured variables")
+return nil
+}
+
+names := make([]string, 0, len(variables))
+for name := range variables {
+names = append(names, name)
+}
+sort.Strings(names)
+
+now := time.Now()
+for _, name := range names {
+v := variables[name]
+value := template.FormatValue(v.Value)
+if v.Secret {
+value = redact.Mask
+}
+fmt.Printf("vars.%s = %s\n", name, value)
+fmt.Printf("  Captured: %s by %s\n", v.CapturedAt.Format("2006-01-02 15:04:05"), v.Source)
+if v.ExpiresAt != nil {
+fmt.Printf("  Expires: %s (in %s)\n", v.ExpiresAt.Format("2006-01-02 15:04:05"), v.ExpiresAt.Sub(now).Round(time.Second))
+}
+}
+return nil
+}
+
+func (c *CLI) handleVarsClear(args []string) error {
+if len(args) == 0 {
+if err := c.vars.Clear(); err != nil {
+return fmt.Errorf("clearing variables: %w", err)
+}
+fmt.Println("Captured variables cleared")
+return nil
+}
+
+missing, err := c.vars.Delete(args...)
+if err != nil {
+return fmt.Errorf("clearing variables: %w", err)
+}
+if len(missing) > 0 {
+return fmt.Errorf("no captured variable named: %s", strings.Join(missing, ", "))
+}
+fmt.Printf("Cleared %d variable(s)\n", len(args))
+return nil
+}
+
+// sortedKeys returns the names of an API's captures in order
+func sortedKeys(captures map[string]config.CaptureSpec) []string {
+names := make([]string, 0, len(captures))
+for name := range captures {
+names = append(names, name)
+}
+sort.Strings(names)
+return names
+}
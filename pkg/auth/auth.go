@@ -0,0 +1,149 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/zqtools/apicli/pkg/config"
+)
+
+// Provider authenticates outgoing requests. Apply runs after the request
+// is fully built, so signing providers see the final URL, headers and body.
+type Provider interface {
+	Apply(req *http.Request) error
+
+	// Secrets returns the values the provider sends or signs with, so they
+	// can be masked in output and history
+	Secrets() []string
+}
+
+// Refresher is implemented by providers whose credentials can be renewed
+// when the server rejects them with 401 Unauthorized
+type Refresher interface {
+	Refresh(ctx context.Context) error
+}
+
+// Options holds what providers need beyond their configuration
+type Options struct {
+	// Tokens caches OAuth2 tokens between runs
+	Tokens *TokenCache
+	// HTTPClient fetches OAuth2 tokens
+	HTTPClient *http.Client
+}
+
+// New creates the provider for a rendered auth block. It returns nil for
+// type none.
+func New(cfg *config.AuthConfig, opts Options) (Provider, error) {
+	if err := cfg.Check(); err != nil {
+		return nil, err
+	}
+
+	switch cfg.Type {
+	case "", "none":
+		return nil, nil
+	case "bearer":
+		return &bearerProvider{token: cfg.Token}, nil
+	case "basic":
+		return &basicProvider{username: cfg.Username, password: cfg.Password}, nil
+	case "apikey":
+		return &apiKeyProvider{key: cfg.Key, name: cfg.Name, inQuery: cfg.In == "query"}, nil
+	case "hmac":
+		return newHMACProvider(cfg), nil
+	case "aws-sigv4":
+		return newSigV4Provider(cfg), nil
+	case "oauth2":
+		return newOAuth2Provider(cfg, opts), nil
+	}
+	return nil, fmt.Errorf("unknown auth type '%s'", cfg.Type)
+}
+
+// bearerProvider sends a static bearer token
+type bearerProvider struct {
+	token string
+}
+
+func (p *bearerProvider) Apply(req *http.Request) error {
+	req.Header.Set("Authorization", "Bearer "+p.token)
+	return nil
+}
+
+func (p *bearerProvider) Secrets() []string {
+	return []string{p.token}
+}
+
+// basicProvider sends HTTP basic credentials
+type basicProvider struct {
+	username string
+	password string
+}
+
+func (p *basicProvider) Apply(req *http.Request) error {
+	req.SetBasicAuth(p.username, p.password)
+	return nil
+}
+
+func (p *basicProvider) Secrets() []string {
+	return []string{p.password}
+}
+
+// apiKeyProvider sends a key in a header or a query parameter
+type apiKeyProvider struct {
+	key     string
+	name    string
+	inQuery bool
+}
+
+func (p *apiKeyProvider) Apply(req *http.Request) error {
+	if p.inQuery {
+		q := req.URL.Query()
+		q.Set(p.name, p.key)
+		req.URL.RawQuery = q.Encode()
+		return nil
+	}
+	req.Header.Set(p.name, p.key)
+	return nil
+}
+
+func (p *apiKeyProvider) Secrets() []string {
+	return []string{p.key}
+}
+
+// hashBody returns the hex SHA-256 of the request body for signing. A body
+// that can be replayed is hashed as it is read, so files and forms are never
+// held in memory. The request is left with an equivalent, unread body.
+func hashBody(req *http.Request) (string, error) {
+	hash := sha256.New()
+	if req.Body == nil || req.Body == http.NoBody {
+		return hex.EncodeToString(hash.Sum(nil)), nil
+	}
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return "", fmt.Errorf("reading body for signing: %w", err)
+		}
+		defer body.Close()
+		if _, err := io.Copy(hash, body); err != nil {
+			return "", fmt.Errorf("reading body for signing: %w", err)
+		}
+		return hex.EncodeToString(hash.Sum(nil)), nil
+	}
+
+	// Streamed bodies are read into memory once so they can be signed
+	data, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return "", fmt.Errorf("reading body for signing: %w", err)
+	}
+	req.Body = io.NopCloser(bytes.NewReader(data))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(data)), nil
+	}
+	req.ContentLength = int64(len(data))
+	hash.Write(data)
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"hash"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/zqtools/apicli/pkg/config"
+)
+
+// hmacProvider signs requests with a shared secret. The signed string is
+//
+//	METHOD \n REQUEST-URI \n TIMESTAMP \n hex(sha256(body))
+//
+// where TIMESTAMP is the Unix time sent in X-Timestamp. The hex signature is
+// sent in the configured header.
+type hmacProvider struct {
+	secret string
+	hash   func() hash.Hash
+	header string
+	keyID  string
+	now    func() time.Time
+}
+
+func newHMACProvider(cfg *config.AuthConfig) *hmacProvider {
+	p := &hmacProvider{
+		secret: cfg.Secret,
+		hash:   sha256.New,
+		header: cfg.Header,
+		keyID:  cfg.KeyID,
+		now:    time.Now,
+	}
+	switch cfg.Algorithm {
+	case "sha1":
+		p.hash = sha1.New
+	case "sha512":
+		p.hash = sha512.New
+	}
+	if p.header == "" {
+		p.header = "X-Signature"
+	}
+	return p
+}
+
+func (p *hmacProvider) Apply(req *http.Request) error {
+	bodyHash, err := hashBody(req)
+	if err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(p.now().Unix(), 10)
+
+	stringToSign := req.Method + "\n" + req.URL.RequestURI() + "\n" + timestamp + "\n" + bodyHash
+	mac := hmac.New(p.hash, []byte(p.secret))
+	mac.Write([]byte(stringToSign))
+
+	req.Header.Set("X-Timestamp", timestamp)
+	if p.keyID != "" {
+		req.Header.Set("X-Key-Id", p.keyID)
+	}
+	req.Header.Set(p.header, hex.EncodeToString(mac.Sum(nil)))
+	return nil
+}
+
+func (p *hmacProvider) Secrets() []string {
+	return []string{p.secret}
+}
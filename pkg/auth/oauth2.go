@@ -0,0 +1,344 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/zqtools/apicli/pkg/config"
+)
+
+// expiryMargin is how long before its expiry a cached token is replaced, so
+// it does not expire while a request is in flight
+const expiryMargin = 30 * time.Second
+
+// Token is an OAuth2 access token as cached on disk, together with what
+// it was issued for
+type Token struct {
+	AccessToken  string    `json:"access_token"`
+	TokenType    string    `json:"token_type,omitempty"`
+	RefreshToken string    `json:"refresh_token,omitempty"`
+	ExpiresAt    time.Time `json:"expires_at,omitempty"`
+	ObtainedAt   time.Time `json:"obtained_at"`
+	TokenURL     string    `json:"token_url"`
+	ClientID     string    `json:"client_id"`
+	Scopes       []string  `json:"scopes,omitempty"`
+}
+
+// Valid reports whether the token can still be used
+func (t *Token) Valid() bool {
+	if t == nil || t.AccessToken == "" {
+		return false
+	}
+	return t.ExpiresAt.IsZero() || time.Now().Add(expiryMargin).Before(t.ExpiresAt)
+}
+
+// oauth2Provider obtains access tokens from a token endpoint and caches
+// them until they expire
+type oauth2Provider struct {
+	cfg    config.AuthConfig
+	cache  *TokenCache
+	key    string
+	client *http.Client
+	token  *Token
+}
+
+func newOAuth2Provider(cfg *config.AuthConfig, opts Options) *oauth2Provider {
+	client := opts.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+	return &oauth2Provider{
+		cfg:    *cfg,
+		cache:  opts.Tokens,
+		client: client,
+		key:    cacheKey(cfg),
+	}
+}
+
+// cacheKey identifies the tokens issued for one client and scope
+func cacheKey(cfg *config.AuthConfig) string {
+	sum := sha256.Sum256([]byte(strings.Join([]string{
+		cfg.TokenURL, cfg.ClientID, cfg.GrantType, strings.Join(cfg.Scopes, " "), cfg.Audience,
+	}, "\n")))
+	return hex.EncodeToString(sum[:])
+}
+
+func (p *oauth2Provider) Apply(req *http.Request) error {
+	token, err := p.Token(req.Context())
+	if err != nil {
+		return err
+	}
+	tokenType := token.TokenType
+	if tokenType == "" || strings.EqualFold(tokenType, "bearer") {
+		tokenType = "Bearer"
+	}
+	req.Header.Set("Authorization", tokenType+" "+token.AccessToken)
+	return nil
+}
+
+func (p *oauth2Provider) Secrets() []string {
+	secrets := []string{p.cfg.ClientSecret, p.cfg.RefreshToken}
+	if p.token != nil {
+		secrets = append(secrets, p.token.AccessToken, p.token.RefreshToken)
+	}
+	return secrets
+}
+
+// Token returns a valid access token, from the cache when possible. An
+// expired token is renewed with its refresh token if it has one.
+func (p *oauth2Provider) Token(ctx context.Context) (*Token, error) {
+	if p.token.Valid() {
+		return p.token, nil
+	}
+
+	cached, err := p.cache.load(p.key)
+	if err != nil {
+		return nil, err
+	}
+	if cached.Valid() {
+		p.token = cached
+		return cached, nil
+	}
+	return p.renew(ctx, cached)
+}
+
+// Refresh discards the current access token, which the server rejected,
+// and obtains a new one
+func (p *oauth2Provider) Refresh(ctx context.Context) error {
+	previous := p.token
+	if previous == nil {
+		cached, err := p.cache.load(p.key)
+		if err != nil {
+			return err
+		}
+		previous = cached
+	}
+	_, err := p.renew(ctx, previous)
+	return err
+}
+
+// renew obtains a new token, using the previous token's refresh token when
+// there is one. A client that can get tokens with its own credentials falls
+// back to them when the refresh token is no longer accepted.
+func (p *oauth2Provider) renew(ctx context.Context, previous *Token) (*Token, error) {
+	refreshToken := p.cfg.RefreshToken
+	if previous != nil && previous.RefreshToken != "" {
+		refreshToken = previous.RefreshToken
+	}
+
+	var token *Token
+	var err error
+	if refreshToken != "" {
+		token, err = p.fetch(ctx, url.Values{"grant_type": {"refresh_token"}, "refresh_token": {refreshToken}})
+		if err != nil && p.cfg.GrantType != "refresh_token" {
+			token, err = p.fetch(ctx, url.Values{"grant_type": {"client_credentials"}})
+			refreshToken = ""
+		}
+	} else {
+		token, err = p.fetch(ctx, url.Values{"grant_type": {"client_credentials"}})
+	}
+	if err != nil {
+		return nil, err
+	}
+	if token.RefreshToken == "" {
+		token.RefreshToken = refreshToken
+	}
+
+	if err := p.cache.save(p.key, token); err != nil {
+		return nil, err
+	}
+	p.token = token
+	return token, nil
+}
+
+// fetch requests a token from the token endpoint
+func (p *oauth2Provider) fetch(ctx context.Context, form url.Values) (*Token, error) {
+	if len(p.cfg.Scopes) > 0 {
+		form.Set("scope", strings.Join(p.cfg.Scopes, " "))
+	}
+	if p.cfg.Audience != "" {
+		form.Set("audience", p.cfg.Audience)
+	}
+	if p.cfg.ClientAuth == "body" {
+		form.Set("client_id", p.cfg.ClientID)
+		if p.cfg.ClientSecret != "" {
+			form.Set("client_secret", p.cfg.ClientSecret)
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.cfg.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("creating token request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.cfg.ClientAuth != "body" {
+		req.SetBasicAuth(url.QueryEscape(p.cfg.ClientID), url.QueryEscape(p.cfg.ClientSecret))
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("requesting token: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("reading token response: %w", err)
+	}
+
+	var result struct {
+		AccessToken      string      `json:"access_token"`
+		TokenType        string      `json:"token_type"`
+		RefreshToken     string      `json:"refresh_token"`
+		ExpiresIn        json.Number `json:"expires_in"`
+		Error            string      `json:"error"`
+		ErrorDescription string      `json:"error_description"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil && resp.StatusCode < 300 {
+		return nil, fmt.Errorf("parsing token response: %w", err)
+	}
+	if resp.StatusCode >= 300 || result.Error != "" {
+		message := result.Error
+		if result.ErrorDescription != "" {
+			message += ": " + result.ErrorDescription
+		}
+		if message == "" {
+			message = strings.TrimSpace(string(body))
+		}
+		return nil, fmt.Errorf("token endpoint returned %s: %s", resp.Status, message)
+	}
+	if result.AccessToken == "" {
+		return nil, fmt.Errorf("token response has no access_token")
+	}
+
+	now := time.Now()
+	token := &Token{
+		AccessToken:  result.AccessToken,
+		TokenType:    result.TokenType,
+		RefreshToken: result.RefreshToken,
+		ObtainedAt:   now,
+		TokenURL:     p.cfg.TokenURL,
+		ClientID:     p.cfg.ClientID,
+		Scopes:       p.cfg.Scopes,
+	}
+	if seconds, err := result.ExpiresIn.Int64(); err == nil && seconds > 0 {
+		token.ExpiresAt = now.Add(time.Duration(seconds) * time.Second)
+	}
+	// A JWT's own expiry wins when it is earlier than the one the endpoint
+	// reported, so the token is refreshed before the API starts rejecting it
+	if exp, ok := jwtExpiry(token.AccessToken); ok && (token.ExpiresAt.IsZero() || exp.Before(token.ExpiresAt)) {
+		token.ExpiresAt = exp
+	}
+	return token, nil
+}
+
+// TokenCache stores tokens as one JSON file per client and scope. A nil
+// cache stores nothing.
+type TokenCache struct {
+	dir string
+}
+
+// NewTokenCache creates a cache of tokens in dir
+func NewTokenCache(dir string) *TokenCache {
+	return &TokenCache{dir: dir}
+}
+
+func (c *TokenCache) path(key string) string {
+	return filepath.Join(c.dir, key+".json")
+}
+
+func (c *TokenCache) load(key string) (*Token, error) {
+	if c == nil {
+		return nil, nil
+	}
+	data, err := os.ReadFile(c.path(key))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading token cache: %w", err)
+	}
+	var token Token
+	if err := json.Unmarshal(data, &token); err != nil {
+		// A corrupt entry is replaced by a fresh token
+		return nil, nil
+	}
+	return &token, nil
+}
+
+func (c *TokenCache) save(key string, token *Token) error {
+	if c == nil {
+		return nil
+	}
+	if err := os.MkdirAll(c.dir, 0700); err != nil {
+		return fmt.Errorf("creating token cache: %w", err)
+	}
+	data, err := json.MarshalIndent(token, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(c.path(key), data, 0600); err != nil {
+		return fmt.Errorf("writing token cache: %w", err)
+	}
+	return nil
+}
+
+// List returns every cached token, ordered by token URL and client
+func (c *TokenCache) List() ([]*Token, error) {
+	files, err := c.files()
+	if err != nil {
+		return nil, err
+	}
+
+	var tokens []*Token
+	for _, file := range files {
+		token, err := c.load(strings.TrimSuffix(filepath.Base(file), ".json"))
+		if err != nil {
+			return nil, err
+		}
+		if token != nil {
+			tokens = append(tokens, token)
+		}
+	}
+	sort.Slice(tokens, func(i, j int) bool {
+		if tokens[i].TokenURL != tokens[j].TokenURL {
+			return tokens[i].TokenURL < tokens[j].TokenURL
+		}
+		return tokens[i].ClientID < tokens[j].ClientID
+	})
+	return tokens, nil
+}
+
+// Clear removes every cached token and returns how many there were
+func (c *TokenCache) Clear() (int, error) {
+	files, err := c.files()
+	if err != nil {
+		return 0, err
+	}
+	for _, file := range files {
+		if err := os.Remove(file); err != nil {
+			return 0, fmt.Errorf("removing cached token: %w", err)
+		}
+	}
+	return len(files), nil
+}
+
+func (c *TokenCache) files() ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(c.dir, "*.json"))
+	if err != nil {
+		return nil, fmt.Errorf("listing token cache: %w", err)
+	}
+	return files, nil
+}
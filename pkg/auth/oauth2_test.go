@@ -0,0 +1,161 @@
+package auth
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zqtools/apicli/pkg/config"
+)
+
+// tokenServer is a token endpoint that issues tok-1, tok-2, ... and records
+// the grant type of every request
+type tokenServer struct {
+	*httptest.Server
+	mu     sync.Mutex
+	grants []string
+	// token, when set, builds the access token for the nth request
+	token func(n int) string
+}
+
+func newTokenServer(t *testing.T) *tokenServer {
+	s := &tokenServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Errorf("parsing token request: %v", err)
+		}
+		if id, secret, _ := r.BasicAuth(); id != "client" || secret != "secret" {
+			t.Errorf("client credentials = %q, %q", id, secret)
+		}
+
+		s.mu.Lock()
+		s.grants = append(s.grants, r.PostForm.Get("grant_type"))
+		n := len(s.grants)
+		s.mu.Unlock()
+
+		access := fmt.Sprintf("tok-%d", n)
+		if s.token != nil {
+			access = s.token(n)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token":  access,
+			"token_type":    "bearer",
+			"expires_in":    3600,
+			"refresh_token": fmt.Sprintf("rt-%d", n),
+		})
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *tokenServer) requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.grants...)
+}
+
+func newTestOAuth2(t *testing.T, tokenURL string, cache *TokenCache) *oauth2Provider {
+	t.Helper()
+	cfg := &config.AuthConfig{Type: "oauth2", TokenURL: tokenURL, ClientID: "client", ClientSecret: "secret"}
+	provider, err := New(cfg, Options{Tokens: cache})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	return provider.(*oauth2Provider)
+}
+
+func authorization(t *testing.T, p Provider) string {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "http://api.test/x", nil)
+	if err := p.Apply(req); err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	return req.Header.Get("Authorization")
+}
+
+// jwt returns an unsigned JWT whose exp claim is at exp
+func jwt(exp time.Time) string {
+	encode := base64.RawURLEncoding.EncodeToString
+	claims := fmt.Sprintf(`{"exp":%d}`, exp.Unix())
+	return encode([]byte(`{"alg":"none"}`)) + "." + encode([]byte(claims)) + ".sig"
+}
+
+func TestOAuth2FetchesToken(t *testing.T) {
+	server := newTokenServer(t)
+	p := newTestOAuth2(t, server.URL, NewTokenCache(t.TempDir()))
+
+	if got := authorization(t, p); got != "Bearer tok-1" {
+		t.Errorf("Authorization = %q, want %q", got, "Bearer tok-1")
+	}
+	if got := server.requests(); len(got) != 1 || got[0] != "client_credentials" {
+		t.Errorf("token requests = %v, want [client_credentials]", got)
+	}
+}
+
+func TestOAuth2UsesCachedToken(t *testing.T) {
+	server := newTokenServer(t)
+	cache := NewTokenCache(t.TempDir())
+
+	authorization(t, newTestOAuth2(t, server.URL, cache))
+	// A later run reads the token from the cache instead of fetching one
+	if got := authorization(t, newTestOAuth2(t, server.URL, cache)); got != "Bearer tok-1" {
+		t.Errorf("Authorization = %q, want the cached %q", got, "Bearer tok-1")
+	}
+	if got := server.requests(); len(got) != 1 {
+		t.Errorf("token requests = %v, want exactly one", got)
+	}
+
+	tokens, err := cache.List()
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(tokens) != 1 || tokens[0].RefreshToken != "rt-1" {
+		t.Errorf("cached tokens = %+v, want one with refresh token rt-1", tokens)
+	}
+}
+
+func TestOAuth2RefreshesWhenJWTExpires(t *testing.T) {
+	server := newTokenServer(t)
+	// The first token's exp falls inside the expiry margin although the
+	// endpoint reports an hour
+	server.token = func(n int) string {
+		if n == 1 {
+			return jwt(time.Now().Add(expiryMargin / 2))
+		}
+		return fmt.Sprintf("tok-%d", n)
+	}
+	p := newTestOAuth2(t, server.URL, NewTokenCache(t.TempDir()))
+
+	first := authorization(t, p)
+	if got := authorization(t, p); got != "Bearer tok-2" || got == first {
+		t.Errorf("Authorization = %q, want the refreshed %q", got, "Bearer tok-2")
+	}
+	want := []string{"client_credentials", "refresh_token"}
+	if got := server.requests(); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("token requests = %v, want %v", got, want)
+	}
+}
+
+func TestOAuth2RefreshReplacesRejectedToken(t *testing.T) {
+	server := newTokenServer(t)
+	p := newTestOAuth2(t, server.URL, NewTokenCache(t.TempDir()))
+
+	authorization(t, p)
+	if err := p.Refresh(context.Background()); err != nil {
+		t.Fatalf("Refresh: %v", err)
+	}
+	if got := authorization(t, p); got != "Bearer tok-2" {
+		t.Errorf("Authorization = %q, want %q", got, "Bearer tok-2")
+	}
+	want := []string{"client_credentials", "refresh_token"}
+	if got := server.requests(); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("token requests = %v, want %v", got, want)
+	}
+}
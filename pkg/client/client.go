@@ -2,10 +2,10 @@ package client
 
 import (
 "bytes"
+"context"
 "encoding/json"
 "fmt"
 "io"
-"mime/multipart"
 "net/http"
 "net/http/httputil"
 "os"
@@ -14,9 +14,12 @@ import (
 "time"
 
 "github.com/google/uuid"
+"github.com/zqtools/apicli/pkg/auth"
 "github.com/zqtools/apicli/pkg/config"
 "github.com/zqtools/apicli/pkg/history"
+"github.com/zqtools/apicli/pkg/redact"
 "github.com/zqtools/apicli/pkg/template"
+"github.com/zqtools/apicli/pkg/vars"
 )
 
 // Client handles HTTP request execution
@@ -27,11 +30,21 @@ renderer   *template.Renderer
 history   *history.Manager
 modulePath string
 apiName    string
+environment string
+context    string
+redactor   *redact.Redactor
+vars       *vars.Store
+captures   map[string]config.CaptureSpec
+tokens     *auth.TokenCache
+auth       auth.Provider
+streamed   bool
+// uploadProgress draws a progress bar for request bodies as they are sent
+uploadProgress bool
 }
 
 // NewClient creates a new API client
 func NewClient(params map[string]interface{}, verbose bool, historyManager *history.Manager, modulePath, apiName string) *Client {
-return &Client{
+c := &Client{
 httpClient: &http.Client{},
 verbose:    verbose,
 renderer:   template.NewRenderer(params),
@@ -39,16 +52,72 @@ history:   historyManager,
 modulePath: modulePath,
 apiName:    apiName,
 }
+c.SetRedactor(redact.New(nil))
+return c
+}
+
+// SetEnvironment selects the named environment whose variables are used
+// to resolve template references that are not parameters
+func (c *Client) SetEnvironment(name string, variables map[string]string) {
+c.environment = name
+c.renderer.SetVariables(variables)
+}
+
+// SetContext records the name of the context that supplied parameter values
+func (c *Client) SetContext(name string) {
+c.context = name
+}
+
+// SetRedactor sets the redactor used to mask secrets in verbose output and history
+func (c *Client) SetRedactor(redactor *redact.Redactor) {
+c.redactor = redactor
+c.renderer.SetMasker(redactor.AddValue)
+}
+
+// SetTemplates sets the API's precompiled templates, so rendering reuses them
+// and reports errors with their position in the configuration
+func (c *Client) SetTemplates(templates *template.Set) {
+c.renderer.SetTemplates(templates)
+}
+
+// SetVarStore sets the store of captured variables that templates reach as
+// ${vars.NAME} and that captures are saved to
+func (c *Client) SetVarStore(store *vars.Store) {
+c.vars = store
+}
+
+// SetCaptures sets the values to capture from a successful response
+func (c *Client) SetCaptures(captures map[string]config.CaptureSpec) {
+c.captures = captures
+}
+
+// SetTokenCache sets where OAuth2 access tokens are cached between runs
+func (c *Client) SetTokenCache(tokens *auth.TokenCache) {
+c.tokens = tokens
+}
+
+// SetCredentials sets the values returned by the module's credential helper,
+// which templates reach as ${credentials.NAME}
+func (c *Client) SetCredentials(values map[string]string) {
+c.renderer.SetCredentials(values)
+}
+
+// SetFunc provides a template function that needs state of the command,
+// such as secret()
+func (c *Client) SetFunc(name string, call func(args []interface{}) (interface{}, error)) {
+c.renderer.SetFunc(name, call)
 }
 
 // ExecuteRequest executes an API request based on the given specification
-func (c *Client) ExecuteRequest(spec config.RequestSpec) (string, error) {
+func (c *Client) ExecuteRequest(ctx context.Context, spec config.RequestSpec) (string, error) {
 // Initialize history entry
 historyEntry := history.Entry{
 ID:         uuid.New().String(),
 Timestamp:  time.Now(),
 Module:     c.modulePath,
 API:        c.apiName,
+Environment: c.environment,
+Context:    c.context,
 Parameters: make(map[string]string),
 Request: history.Request{
 Method:      spec.Method,
@@ -56,31 +125,51 @@ Headers:     make(map[string]string),
 },
 }
 
-// Save parameters
-for k, v := range c.renderer.GetParams() {
-if str, ok := v.(string); ok {
-historyEntry.Parameters[k] = str
-} else {
-historyEntry.Parameters[k] = fmt.Sprintf("%v", v)
+// Make captured variables available to templates, masking secret ones
+if c.vars != nil {
+variables, err := c.vars.Load()
+if err != nil {
+return "", err
 }
+values := make(map[string]interface{}, len(variables))
+for name, v := range variables {
+values[name] = v.Value
+if v.Secret {
+c.redactor.AddValue(template.FormatValue(v.Value))
 }
+}
+c.renderer.SetCaptured(values)
+}
+
 // Render URL template
-url, err := c.renderer.Render(spec.URL)
+url, err := c.renderer.RenderURL(spec.URL)
 if err != nil {
 return "", fmt.Errorf("rendering URL template: %w", err)
 }
 
 var req *http.Request
+var body string
 var bodyErr error
 
 // Create request based on specification
 switch {
 case spec.BodyFile != "":
 req, bodyErr = c.createRequestFromFile(spec.Method, url, spec.BodyFile)
+case c.fileValue(spec.Body) != nil:
+req, bodyErr = c.createRequestFromFile(spec.Method, url, spec.Body)
 case len(spec.Form) > 0:
-req, bodyErr = c.createFormRequest(spec.Method, url, spec.Form)
+historyEntry.Request.Form = make(map[string]string)
+req, bodyErr = c.createFormRequest(spec.Method, url, spec.Form, historyEntry.Request.Form)
+case spec.HasJSONBody():
+body, bodyErr = c.renderer.RenderJSONTree(&spec.JSON)
+if bodyErr == nil {
+req, bodyErr = c.createBodyRequest(spec.Method, url, body, true)
+}
 case spec.Body != "":
-req, bodyErr = c.createBodyRequest(spec.Method, url, spec.Body)
+body, bodyErr = c.renderBody(spec.Body, spec.IsJSONBody())
+if bodyErr == nil {
+req, bodyErr = c.createBodyRequest(spec.Method, url, body, spec.IsJSONBody())
+}
 default:
 req, bodyErr = http.NewRequest(spec.Method, url, nil)
 }
@@ -88,6 +177,7 @@ req, bodyErr = http.NewRequest(spec.Method, url, nil)
 if bodyErr != nil {
 return "", fmt.Errorf("creating request: %w", bodyErr)
 }
+req = req.WithContext(ctx)
 
 // Add query parameters
 if len(spec.Params) > 0 {
@@ -95,7 +185,7 @@ queryParams := make(map[string]string)
 if err := c.addQueryParams(req, spec.Params, queryParams); err != nil {
 return "", err
 }
-historyEntry.Request.QueryParams = queryParams
+historyEntry.Request.QueryParams = c.redactor.Map(queryParams)
 }
 
 // Add headers
@@ -103,15 +193,34 @@ if err := c.addHeaders(req, spec.Headers, historyEntry.Request.Headers); err !=
 return "", err
 }
 
+// Ask for the rest of a partial download before the request is signed
+var dl *download
+if spec.Download != "" {
+if dl, err = c.prepareDownload(spec, req, historyEntry.Request.Headers); err != nil {
+return "", err
+}
+}
+
+// Apply authentication
+if err := c.applyAuth(req, spec.Auth, historyEntry.Request.Headers); err != nil {
+return "", err
+}
+
+// Apply the request timeout and retry policy
+policy, err := spec.RetryPolicy()
+if err != nil {
+return "", err
+}
+
 // Print request details if verbose mode is enabled
 // Save request URL after all parameters are added
-historyEntry.Request.URL = req.URL.String()
+historyEntry.Request.URL = c.redactor.String(req.URL.String())
 
-// Save request body if present
-if spec.Body != "" {
-historyEntry.Request.Body = spec.Body
+// Save the serialized request body if present
+if body != "" {
+historyEntry.Request.Body = c.redactor.String(body)
 } else if len(spec.Form) > 0 {
-historyEntry.Request.Form = spec.Form
+historyEntry.Request.Form = c.redactor.Map(historyEntry.Request.Form)
 }
 
 if c.verbose {
@@ -119,50 +228,169 @@ c.dumpRequest(req)
 }
 
 // Execute request
-resp, err := c.httpClient.Do(req)
+resp, attempts, err := c.send(req, policy)
+historyEntry.Attempts = attempts
 if err != nil {
+if ctx.Err() != nil {
+historyEntry.State = history.StateCancelled
+c.recordHistory(&historyEntry)
+}
+if attempts > 1 {
+return "", fmt.Errorf("sending request (%d attempts): %w", attempts, err)
+}
 return "", fmt.Errorf("sending request: %w", err)
 }
+
+// Renew rejected credentials and send the request once more
+if resp.StatusCode == http.StatusUnauthorized {
+retried, err := c.retryUnauthorized(req, policy.Timeout, historyEntry.Request.Headers)
+if err != nil {
+resp.Body.Close()
+return "", err
+}
+if retried != nil {
+resp.Body.Close()
+resp = retried
+historyEntry.Attempts++
+}
+}
 defer resp.Body.Close()
 
 // Initialize response in history entry
 historyEntry.Response = history.Response{
 StatusCode: resp.StatusCode,
-Headers:    make(map[string]string),
 }
 
-// Copy response headers
-for k, v := range resp.Header {
-if len(v) > 0 {
-historyEntry.Response.Headers[k] = v[0]
-}
+// Downloads are saved to disk, and event streams and chunked bodies are
+// printed as they arrive instead
+saving := dl != nil && dl.accepts(resp)
+var mode streamMode
+if !saving {
+mode = streamModeOf(spec, resp)
 }
 
 // Print response details if verbose mode is enabled
 if c.verbose {
-c.dumpResponse(resp)
+c.dumpResponse(resp, !saving && mode == 0)
 }
 
 // Read and format response
-respStr, err := c.formatResponse(resp)
+var respStr string
+switch {
+case saving:
+size, err := c.saveDownload(resp, dl)
+// An interrupted download is left in its partial file to be resumed
+saved := dl.path
+if err != nil {
+saved = dl.partial
+}
+historyEntry.Response.File = saved
+if abs, absErr := filepath.Abs(saved); absErr == nil {
+historyEntry.Response.File = abs
+}
+historyEntry.Response.Size = size
+if err != nil {
+c.recordInterrupted(ctx, resp, &historyEntry)
+return "", err
+}
+case mode != 0:
+c.streamed = true
+transcript, err := c.streamResponse(resp, mode)
+historyEntry.Response.Streamed = true
+historyEntry.Response.Events = transcript.events
 if err != nil {
+// Keep what arrived before the stream was interrupted
+historyEntry.Response.Body = c.redactor.String(transcript.String())
+c.recordInterrupted(ctx, resp, &historyEntry)
 return "", err
 }
+respStr = transcript.String()
+default:
+respStr, err = c.formatResponse(resp)
+if err != nil {
+if ctx.Err() != nil {
+historyEntry.State = history.StateCancelled
+c.recordHistory(&historyEntry)
+}
+return "", err
+}
+}
+
+// Capture values from successful responses before anything is recorded,
+// so captured secrets are masked in history. A stream has no single body
+// to capture from, only a transcript of its events.
+if len(c.captures) > 0 && c.vars != nil && resp.StatusCode >= 200 && resp.StatusCode < 300 {
+if c.streamed {
+fmt.Fprintf(os.Stderr, "Warning: nothing captured; captures are not taken from streamed responses\n")
+} else {
+c.captureValues(resp, respStr)
+}
+}
+
+// Copy response headers
+historyEntry.Response.Headers = c.responseHeaders(resp)
 
 // Save response body
-historyEntry.Response.Body = respStr
+historyEntry.Response.Body = c.redactor.String(respStr)
 
-// Record history if manager is available
-if c.history != nil {
-if err := c.history.AddEntry(historyEntry); err != nil {
-// Just log the error, don't fail the request
-fmt.Fprintf(os.Stderr, "Warning: Failed to record history: %v\n", err)
+c.recordHistory(&historyEntry)
+switch {
+case saving:
+if dl.offset > 0 && resp.StatusCode == http.StatusPartialContent {
+return fmt.Sprintf("Saved %s to %s (resumed after %s)", formatSize(historyEntry.Response.Size), dl.path, formatSize(dl.offset)), nil
 }
+return fmt.Sprintf("Saved %s to %s", formatSize(historyEntry.Response.Size), dl.path), nil
+case c.streamed:
+return "", nil
 }
-
 return respStr, nil
 }
 
+// recordInterrupted records a call whose response body was only partly
+// read, noting whether it was cancelled
+func (c *Client) recordInterrupted(ctx context.Context, resp *http.Response, entry *history.Entry) {
+entry.Response.Headers = c.responseHeaders(resp)
+if ctx.Err() != nil {
+entry.State = history.StateCancelled
+}
+c.recordHistory(entry)
+}
+
+// responseHeaders returns the first value of each response header, masked
+// for history
+func (c *Client) responseHeaders(resp *http.Response) map[string]string {
+headers := make(map[string]string)
+for k, v := range resp.Header {
+if len(v) > 0 {
+headers[k] = c.redactor.Header(k, v[0])
+}
+}
+return headers
+}
+
+// Streamed reports whether the response of the last request was printed
+// while it was received rather than returned by ExecuteRequest
+func (c *Client) Streamed() bool {
+return c.streamed
+}
+
+// recordHistory saves the parameters to the entry and adds it to history
+func (c *Client) recordHistory(entry *history.Entry) {
+if c.history == nil {
+return
+}
+
+// Save parameters, masking any that auth providers sent as credentials
+for k, v := range c.renderer.GetParams() {
+entry.Parameters[k] = c.redactor.String(template.FormatValue(v))
+}
+
+if err := c.history.AddEntry(*entry); err != nil {
+// Just log the error, don't fail the request
+fmt.Fprintf(os.Stderr, "Warning: Failed to record history: %v\n", err)
+}
+}
+
 func (c *Client) createRequestFromFile(method, url, filepath string) (*http.Request, error) {
 path, err := c.renderer.Render(filepath)
 if err != nil {
@@ -173,58 +401,57 @@ file, err := os.Open(path)
 if err != nil {
 return nil, fmt.Errorf("opening file: %w", err)
 }
-defer file.Close()
-
-return http.NewRequest(method, url, file)
-}
 
-func (c *Client) createFormRequest(method, url string, form map[string]string) (*http.Request, error) {
-body := &bytes.Buffer{}
-writer := multipart.NewWriter(body)
-
-for field, valueTmpl := range form {
-value, err := c.renderer.Render(valueTmpl)
+// The file is streamed as the body and closed once the request is sent
+req, err := http.NewRequest(method, url, file)
 if err != nil {
-return nil, fmt.Errorf("rendering form field template: %w", err)
+file.Close()
+return nil, err
 }
-
-// Try to open as file first
-if file, err := os.Open(value); err == nil {
-defer file.Close()
-part, err := writer.CreateFormFile(field, filepath.Base(value))
-if err != nil {
-return nil, fmt.Errorf("creating form file: %w", err)
+if info, err := file.Stat(); err == nil {
+req.ContentLength = info.Size()
 }
-if _, err := io.Copy(part, file); err != nil {
-return nil, fmt.Errorf("copying file content: %w", err)
+// Reopening the file lets the body be signed and sent again
+req.GetBody = func() (io.ReadCloser, error) {
+return os.Open(path)
 }
-} else {
-// Not a file, write as regular field
-if err := writer.WriteField(field, value); err != nil {
-return nil, fmt.Errorf("writing form field: %w", err)
+return req, nil
 }
+
+// fileValue returns the file a template consists of, if it is exactly one
+// file-typed parameter
+func (c *Client) fileValue(tmpl string) *template.File {
+value, ok, err := c.renderer.Value(tmpl)
+if err != nil || !ok {
+return nil
 }
+if file, ok := value.(template.File); ok {
+return &file
 }
-
-if err := writer.Close(); err != nil {
-return nil, fmt.Errorf("closing multipart writer: %w", err)
+return nil
 }
 
-req, err := http.NewRequest(method, url, body)
+func (c *Client) renderBody(body string, isJSON bool) (string, error) {
+render := c.renderer.Render
+if isJSON {
+render = c.renderer.RenderJSON
+}
+renderedBody, err := render(body)
 if err != nil {
-return nil, err
+return "", fmt.Errorf("rendering body template: %w", err)
 }
-
-req.Header.Set("Content-Type", writer.FormDataContentType())
-return req, nil
+return renderedBody, nil
 }
 
-func (c *Client) createBodyRequest(method, url, body string) (*http.Request, error) {
-renderedBody, err := c.renderer.Render(body)
+func (c *Client) createBodyRequest(method, url, body string, isJSON bool) (*http.Request, error) {
+req, err := http.NewRequest(method, url, bytes.NewBufferString(body))
 if err != nil {
-return nil, fmt.Errorf("rendering body template: %w", err)
+return nil, err
 }
-return http.NewRequest(method, url, bytes.NewBufferString(renderedBody))
+if isJSON {
+req.Header.Set("Content-Type", "application/json")
+}
+return req, nil
 }
 
 func (c *Client) addQueryParams(req *http.Request, params []config.QueryParam, historyParams map[string]string) error {
@@ -247,22 +474,117 @@ if err != nil {
 return fmt.Errorf("rendering header template: %w", err)
 }
 req.Header.Set(key, value)
-historyHeaders[key] = value
+historyHeaders[key] = c.redactor.Header(key, value)
+}
+return nil
+}
+
+func (c *Client) applyAuth(req *http.Request, spec *config.AuthConfig, historyHeaders map[string]string) error {
+if spec == nil {
+return nil
+}
+
+rendered, err := spec.Render(c.renderer.Render)
+if err != nil {
+return fmt.Errorf("rendering auth settings: %w", err)
+}
+provider, err := auth.New(rendered, auth.Options{Tokens: c.tokens})
+if err != nil {
+return fmt.Errorf("configuring %s auth: %w", spec.Type, err)
 }
+if provider == nil {
 return nil
 }
+c.auth = provider
+return c.applyProvider(req, historyHeaders)
+}
 
+// applyProvider authenticates req and records the headers the provider set,
+// masked, in historyHeaders
+func (c *Client) applyProvider(req *http.Request, historyHeaders map[string]string) error {
+before := make(map[string]string, len(req.Header))
+for name := range req.Header {
+before[name] = req.Header.Get(name)
+}
+
+applyErr := c.auth.Apply(req)
+for _, secret := range c.auth.Secrets() {
+c.redactor.AddValue(secret)
+}
+if applyErr != nil {
+return fmt.Errorf("applying auth: %w", applyErr)
+}
+
+for name := range req.Header {
+if value := req.Header.Get(name); value != before[name] {
+historyHeaders[name] = c.redactor.Header(name, value)
+}
+}
+return nil
+}
+
+// retryUnauthorized renews the credentials of a provider that supports it
+// and sends the request again. It returns nil when the request cannot be
+// retried: the provider cannot renew credentials or the body cannot be
+// replayed.
+func (c *Client) retryUnauthorized(req *http.Request, timeout time.Duration, historyHeaders map[string]string) (*http.Response, error) {
+refresher, ok := c.auth.(auth.Refresher)
+if !ok {
+return nil, nil
+}
+
+retry := req.Clone(req.Context())
+if req.Body != nil && req.Body != http.NoBody {
+if req.GetBody == nil {
+return nil, nil
+}
+body, err := req.GetBody()
+if err != nil {
+return nil, nil
+}
+retry.Body = body
+}
+
+if c.verbose {
+fmt.Println("Received 401 Unauthorized, renewing credentials and retrying")
+}
+if err := refresher.Refresh(req.Context()); err != nil {
+return nil, fmt.Errorf("renewing credentials after 401: %w", err)
+}
+if err := c.applyProvider(retry, historyHeaders); err != nil {
+return nil, err
+}
+if c.verbose {
+c.dumpRequest(retry)
+}
+
+resp, err := c.do(retry, timeout)
+if err != nil {
+return nil, fmt.Errorf("sending request: %w", err)
+}
+return resp, nil
+}
+
+// dumpRequest prints the request, leaving out bodies streamed from files so
+// they are not read into memory
 func (c *Client) dumpRequest(req *http.Request) {
-dump, err := httputil.DumpRequestOut(req, true)
+streamed := false
+switch req.Body.(type) {
+case *os.File, *io.PipeReader:
+streamed = true
+}
+dump, err := httputil.DumpRequestOut(req, !streamed)
 if err == nil {
-fmt.Printf("\n>>> Request:\n%s\n\n", string(dump))
+fmt.Printf("\n>>> Request:\n%s\n\n", c.redactor.Dump(string(dump)))
 }
 }
 
-func (c *Client) dumpResponse(resp *http.Response) {
-dump, err := httputil.DumpResponse(resp, true)
+// dumpResponse prints the response, leaving out the body when it is
+// streamed afterwards
+func (c *Client) dumpResponse(resp *http.Response, body bool) {
+dump, err := httputil.DumpResponse(resp, body)
 if err == nil {
-fmt.Printf("\n<<< Response:\n%s\n\n", string(dump))
+fmt.Printf("\n<<< Response:\n%s\n\n", c.redactor.Dump(string(dump)))
 }
 }
 
@@ -282,6 +604,64 @@ return prettyJSON.String(), nil
 return string(body), nil
 }
 
+// captureValues extracts the configured captures from a response and saves
+// them to the variable store. Failures are reported but do not fail the call.
+func (c *Client) captureValues(resp *http.Response, body string) {
+headers := make(map[string]interface{})
+for name, values := range resp.Header {
+if len(values) > 0 {
+headers[name] = values[0]
+}
+}
+var parsedBody interface{} = body
+// Keep numbers exact so large IDs survive into variables
+var decoded interface{}
+decoder := json.NewDecoder(strings.NewReader(body))
+decoder.UseNumber()
+if err := decoder.Decode(&decoded); err == nil && !decoder.More() {
+parsedBody = decoded
+}
+doc := map[string]interface{}{
+"status":  resp.StatusCode,
+"headers": headers,
+"body":    parsedBody,
+}
+
+now := time.Now()
+updates := make(map[string]vars.Variable)
+for name, capture := range c.captures {
+value, err := vars.Lookup(doc, capture.Path)
+if err != nil {
+fmt.Fprintf(os.Stderr, "Warning: capturing '%s': %v\n", name, err)
+continue
+}
+variable := vars.Variable{
+Value:      value,
+Source:     c.modulePath + "." + c.apiName,
+Secret:     capture.Secret,
+CapturedAt: now,
+}
+if ttl, err := capture.TTLDuration(); err == nil && ttl > 0 {
+expires := now.Add(ttl)
+variable.ExpiresAt = &expires
+}
+if capture.Secret {
+c.redactor.AddValue(template.FormatValue(value))
+}
+updates[name] = variable
+
+if c.verbose {
+fmt.Printf("Captured vars.%s from %s\n", name, capture.Path)
+}
+}
+
+if len(updates) > 0 {
+if err := c.vars.Update(updates); err != nil {
+fmt.Fprintf(os.Stderr, "Warning: Failed to save captured variables: %v\n", err)
+}
+}
+}
+
 func isJSONResponse(header http.Header) bool {
 contentType := header.Get("Content-Type")
 return strings.Contains(contentType, "application/json")
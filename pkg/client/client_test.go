@@ -0,0 +1,127 @@
+package client
+
+import (
+"context"
+"encoding/json"
+"fmt"
+"net/http"
+"net/http/httptest"
+"strings"
+"sync"
+"testing"
+
+"github.com/zqtools/apicli/pkg/auth"
+"github.com/zqtools/apicli/pkg/config"
+"github.com/zqtools/apicli/pkg/history"
+)
+
+// authServer issues tok-1, tok-2, ... from /token and serves /api to
+// requests carrying a token that accept allows
+type authServer struct {
+*httptest.Server
+mu       sync.Mutex
+issued   int
+apiCalls []string
+accept   func(token string) bool
+}
+
+func newAuthServer(t *testing.T, accept func(token string) bool) *authServer {
+s := &authServer{accept: accept}
+s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+s.mu.Lock()
+defer s.mu.Unlock()
+
+switch r.URL.Path {
+case "/token":
+s.issued++
+w.Header().Set("Content-Type", "application/json")
+json.NewEncoder(w).Encode(map[string]interface{}{
+"access_token":  fmt.Sprintf("tok-%d", s.issued),
+"token_type":    "bearer",
+"expires_in":    3600,
+"refresh_token": fmt.Sprintf("rt-%d", s.issued),
+})
+case "/api":
+token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+s.apiCalls = append(s.apiCalls, token)
+if !s.accept(token) {
+w.WriteHeader(http.StatusUnauthorized)
+return
+}
+w.Header().Set("Content-Type", "application/json")
+fmt.Fprint(w, `{"ok":true}`)
+default:
+http.NotFound(w, r)
+}
+}))
+t.Cleanup(s.Close)
+return s
+}
+
+func (s *authServer) calls() []string {
+s.mu.Lock()
+defer s.mu.Unlock()
+return append([]string(nil), s.apiCalls...)
+}
+
+// callOAuth2API sends one request to /api authenticated with oauth2 and
+// returns the history entry recorded for it
+func callOAuth2API(t *testing.T, server *authServer) (history.Entry, error) {
+t.Helper()
+historyManager, err := history.NewManager(t.TempDir())
+if err != nil {
+t.Fatalf("NewManager: %v", err)
+}
+
+c := NewClient(nil, false, historyManager, "m", "api")
+c.SetTokenCache(auth.NewTokenCache(t.TempDir()))
+_, callErr := c.ExecuteRequest(context.Background(), config.RequestSpec{
+Method: http.MethodGet,
+URL:    server.URL + "/api",
+Auth: &config.AuthConfig{
+Type:         "oauth2",
+TokenURL:     server.URL + "/token",
+ClientID:     "client",
+ClientSecret: "secret",
+},
+})
+
+entries, err := historyManager.ListEntries(1)
+if err != nil || len(entries) != 1 {
+t.Fatalf("ListEntries = %v, %v; want one entry", entries, err)
+}
+return entries[0], callErr
+}
+
+func TestUnauthorizedRetriesWithRenewedToken(t *testing.T) {
+server := newAuthServer(t, func(token string) bool { return token != "tok-1" })
+
+entry, err := callOAuth2API(t, server)
+if err != nil {
+t.Fatalf("ExecuteRequest: %v", err)
+}
+if got, want := server.calls(), []string{"tok-1", "tok-2"}; fmt.Sprint(got) != fmt.Sprint(want) {
+t.Errorf("API calls with tokens %v, want %v", got, want)
+}
+if entry.Response.StatusCode != http.StatusOK {
+t.Errorf("recorded status %d, want 200", entry.Response.StatusCode)
+}
+if entry.Attempts != 2 {
+t.Errorf("recorded %d attempts, want 2", entry.Attempts)
+}
+}
+
+func TestUnauthorizedRetriesOnce(t *testing.T) {
+server := newAuthServer(t, func(string) bool { return false })
+
+entry, err := callOAuth2API(t, server)
+if err != nil {
+t.Fatalf("ExecuteRequest: %v", err)
+}
+if got := server.calls(); len(got) != 2 {
+t.Errorf("API calls with tokens %v, want exactly one retry", got)
+}
+if entry.Response.StatusCode != http.StatusUnauthorized {
+t.Errorf("recorded status %d, want 401", entry.Response.StatusCode)
+}
+}
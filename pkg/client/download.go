@@ -0,0 +1,299 @@
+package client
+
+import (
+"fmt"
+"io"
+"mime"
+"net/http"
+"net/url"
+"os"
+"path"
+"path/filepath"
+"strconv"
+"strings"
+"time"
+
+"github.com/zqtools/apicli/pkg/config"
+"github.com/zqtools/apicli/pkg/term"
+)
+
+// partialSuffix marks a download that has not finished yet; the version of
+// the file it holds is kept next to it under validatorSuffix
+const (
+partialSuffix   = ".part"
+validatorSuffix = ".validator"
+)
+
+// download is where a response body is saved
+type download struct {
+// dir is set when the file is named by the response
+dir string
+// path is the finished file; partial holds the body until it is complete
+path    string
+partial string
+// offset is the size of an earlier partial download being resumed
+offset int64
+}
+
+// prepareDownload resolves where the response to req is saved and asks the
+// server for the rest of a partial file left by an earlier call, provided
+// the file has not changed since. The partial file is named from the URL so
+// it is found before the response arrives.
+func (c *Client) prepareDownload(spec config.RequestSpec, req *http.Request, historyHeaders map[string]string) (*download, error) {
+target, err := c.renderer.Render(spec.Download)
+if err != nil {
+return nil, fmt.Errorf("rendering download path: %w", err)
+}
+
+d := &download{path: target}
+info, err := os.Stat(target)
+if (err == nil && info.IsDir()) || strings.HasSuffix(target, "/") || strings.HasSuffix(target, string(os.PathSeparator)) {
+d.dir = target
+d.path = filepath.Join(target, urlFileName(req.URL))
+}
+d.partial = d.path + partialSuffix
+
+if info, err := os.Stat(d.partial); err == nil && info.Size() > 0 {
+// Without a validator nothing tells whether the rest belongs to the same
+// version of the file, so it is downloaded again
+validator, _ := os.ReadFile(d.partial + validatorSuffix)
+if len(validator) == 0 {
+if c.verbose {
+fmt.Printf("Restarting %s: the server gave no ETag or Last-Modified to resume it with\n", d.partial)
+}
+return d, nil
+}
+d.offset = info.Size()
+req.Header.Set("Range", fmt.Sprintf("bytes=%d-", d.offset))
+req.Header.Set("If-Range", string(validator))
+historyHeaders["Range"] = req.Header.Get("Range")
+historyHeaders["If-Range"] = req.Header.Get("If-Range")
+if c.verbose {
+fmt.Printf("Resuming %s from %s\n", d.partial, formatSize(d.offset))
+}
+}
+return d, nil
+}
+
+// accepts reports whether resp is saved rather than printed: error
+// responses are shown as usual
+func (d *download) accepts(resp *http.Response) bool {
+if resp.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+return d.offset > 0
+}
+return resp.StatusCode >= 200 && resp.StatusCode < 300
+}
+
+// saveDownload writes the body of resp to the download's file and returns
+// the size of the file. A failed or cancelled download leaves the partial
+// file behind to be resumed.
+func (c *Client) saveDownload(resp *http.Response, d *download) (int64, error) {
+// The body is read for as long as the transfer takes
+disarmTimeout(resp)
+
+if d.dir != "" {
+if name := dispositionFileName(resp.Header.Get("Content-Disposition")); name != "" {
+d.path = filepath.Join(d.dir, name)
+// The server chose the name, so it must not replace a file that is there
+if _, err := os.Lstat(d.path); err == nil {
+return 0, fmt.Errorf("the server named the download %s, which already exists; remove it or pass a file name to --download", d.path)
+}
+}
+}
+
+var offset int64
+total := resp.ContentLength
+flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+switch resp.StatusCode {
+case http.StatusRequestedRangeNotSatisfiable:
+// The partial file may already hold the whole body
+if _, size, ok := contentRange(resp.Header.Get("Content-Range")); ok && size == d.offset {
+return d.offset, d.finish()
+}
+return 0, fmt.Errorf("the server cannot resume %s; delete it to download again", d.partial)
+case http.StatusPartialContent:
+start, size, ok := contentRange(resp.Header.Get("Content-Range"))
+if !ok || start != d.offset {
+return 0, fmt.Errorf("the server resumed %s at an unexpected position (Content-Range %q)", d.partial, resp.Header.Get("Content-Range"))
+}
+offset, total = d.offset, size
+flags = os.O_WRONLY | os.O_APPEND
+}
+// Any other response carries the whole body, even when a range was asked
+// for: the file changed since the partial download, or the server ignores
+// ranges
+if offset == 0 && d.offset > 0 && c.verbose {
+fmt.Printf("Restarting %s: the server sent the whole file\n", d.partial)
+}
+
+if err := os.MkdirAll(filepath.Dir(d.partial), 0755); err != nil {
+return 0, fmt.Errorf("creating download directory: %w", err)
+}
+file, err := os.OpenFile(d.partial, flags, 0644)
+if err != nil {
+return 0, fmt.Errorf("creating download file: %w", err)
+}
+// Keep the version being saved, so an interrupted download is only resumed
+// from the same one
+if validator := resumeValidator(resp.Header); validator != "" {
+os.WriteFile(d.partial+validatorSuffix, []byte(validator), 0644)
+} else if offset == 0 {
+os.Remove(d.partial + validatorSuffix)
+}
+
+var w io.Writer = file
+var bar *progress
+if term.IsTerminal(os.Stderr) {
+bar = &progress{name: filepath.Base(d.path), done: offset, resumed: offset, total: total, start: time.Now()}
+w = io.MultiWriter(file, bar)
+}
+n, err := io.Copy(w, resp.Body)
+if bar != nil {
+bar.finish()
+}
+if closeErr := file.Close(); err == nil {
+err = closeErr
+}
+if err != nil {
+return offset + n, fmt.Errorf("saving download to %s: %w", d.partial, err)
+}
+return offset + n, d.finish()
+}
+
+// finish moves the completed download to its final name
+func (d *download) finish() error {
+if err := os.Rename(d.partial, d.path); err != nil {
+return fmt.Errorf("saving download: %w", err)
+}
+os.Remove(d.partial + validatorSuffix)
+return nil
+}
+
+// resumeValidator returns what identifies the version of a response for
+// If-Range: its ETag, or its Last-Modified date when the ETag is missing or
+// weak, which If-Range does not accept
+func resumeValidator(header http.Header) string {
+if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+return etag
+}
+return header.Get("Last-Modified")
+}
+
+// contentRange parses a Content-Range header of the form
+// "bytes START-END/SIZE" or "bytes */SIZE"; unknown values are -1
+func contentRange(value string) (start, size int64, ok bool) {
+spec, found := strings.CutPrefix(value, "bytes ")
+if !found {
+return 0, 0, false
+}
+span, total, found := strings.Cut(spec, "/")
+if !found {
+return 0, 0, false
+}
+
+start, size = -1, -1
+var err error
+if total != "*" {
+if size, err = strconv.ParseInt(total, 10, 64); err != nil {
+return 0, 0, false
+}
+}
+if span != "*" {
+first, _, _ := strings.Cut(span, "-")
+if start, err = strconv.ParseInt(first, 10, 64); err != nil {
+return 0, 0, false
+}
+}
+return start, size, true
+}
+
+// dispositionFileName returns the file name suggested by a
+// Content-Disposition header, or "" when there is none
+func dispositionFileName(header string) string {
+if header == "" {
+return ""
+}
+// filename* values are decoded into filename
+_, params, err := mime.ParseMediaType(header)
+if err != nil {
+return ""
+}
+return safeFileName(params["filename"])
+}
+
+// urlFileName names a download after the last segment of its URL path
+func urlFileName(u *url.URL) string {
+if name := safeFileName(path.Base(u.Path)); name != "" {
+return name
+}
+return "download"
+}
+
+// safeFileName strips directories from a name chosen by the server, so a
+// download cannot be written outside its directory
+func safeFileName(name string) string {
+name = path.Base(strings.ReplaceAll(name, "\\", "/"))
+switch name {
+case ".", "..", "/":
+return ""
+}
+return name
+}
+
+// progress draws a progress bar for a download or upload on the terminal
+type progress struct {
+name string
+// done counts the bytes saved so far, including those resumed from an
+// earlier call; total is -1 when the server did not say
+done, resumed, total int64
+start, drawn         time.Time
+}
+
+func (p *progress) Write(b []byte) (int, error) {
+p.done += int64(len(b))
+if time.Since(p.drawn) >= 100*time.Millisecond {
+p.draw()
+}
+return len(b), nil
+}
+
+func (p *progress) draw() {
+p.drawn = time.Now()
+rate := ""
+if elapsed := time.Since(p.start).Seconds(); elapsed > 0 {
+rate = formatSize(int64(float64(p.done-p.resumed)/elapsed)) + "/s"
+}
+
+if p.total <= 0 {
+fmt.Fprintf(os.Stderr, "\r%s %s %s  ", p.name, formatSize(p.done), rate)
+return
+}
+const width = 30
+filled := int(p.done * width / p.total)
+if filled > width {
+filled = width
+}
+fmt.Fprintf(os.Stderr, "\r%s [%s%s] %3d%% %s of %s %s  ", p.name,
+strings.Repeat("=", filled), strings.Repeat(" ", width-filled),
+p.done*100/p.total, formatSize(p.done), formatSize(p.total), rate)
+}
+
+// finish draws the final state and ends the progress line
+func (p *progress) finish() {
+p.draw()
+fmt.Fprintln(os.Stderr)
+}
+
+// formatSize formats a byte count for people, e.g. "1.5 MiB"
+func formatSize(n int64) string {
+const unit = 1024
+if n < unit {
+return fmt.Sprintf("%d B", n)
+}
+div, exp := int64(unit), 0
+for m := n / unit; m >= unit; m /= unit {
+div *= unit
+exp++
+}
+return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
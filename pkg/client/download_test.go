@@ -0,0 +1,307 @@
+package client
+
+import (
+"bytes"
+"context"
+"fmt"
+"net/http"
+"net/http/httptest"
+"os"
+"path/filepath"
+"strings"
+"sync"
+"testing"
+"time"
+
+"github.com/zqtools/apicli/pkg/config"
+)
+
+// fileData is the content the download server serves
+var fileData = bytes.Repeat([]byte("0123456789"), 1000)
+
+// downloadServer serves fileData with ETag "v1", honouring Range and
+// If-Range, and records the Range and If-Range of each request
+type downloadServer struct {
+*httptest.Server
+mu       sync.Mutex
+requests []string
+}
+
+func newDownloadServer(t *testing.T, handler http.HandlerFunc) *downloadServer {
+s := &downloadServer{}
+if handler == nil {
+handler = func(w http.ResponseWriter, r *http.Request) {
+w.Header().Set("ETag", `"v1"`)
+http.ServeContent(w, r, "file.bin", time.Time{}, bytes.NewReader(fileData))
+}
+}
+s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+s.mu.Lock()
+s.requests = append(s.requests, fmt.Sprintf("Range=%q If-Range=%q", r.Header.Get("Range"), r.Header.Get("If-Range")))
+s.mu.Unlock()
+handler(w, r)
+}))
+t.Cleanup(s.Close)
+return s
+}
+
+func (s *downloadServer) request() string {
+s.mu.Lock()
+defer s.mu.Unlock()
+if len(s.requests) != 1 {
+return fmt.Sprintf("%d requests", len(s.requests))
+}
+return s.requests[0]
+}
+
+// partialDownload leaves a partial download of target holding data, with
+// the given validator unless it is empty
+func partialDownload(t *testing.T, target string, data []byte, validator string) {
+t.Helper()
+if err := os.WriteFile(target+partialSuffix, data, 0644); err != nil {
+t.Fatal(err)
+}
+if validator != "" {
+if err := os.WriteFile(target+partialSuffix+validatorSuffix, []byte(validator), 0644); err != nil {
+t.Fatal(err)
+}
+}
+}
+
+func downloadTo(server *downloadServer, target string) (string, error) {
+c := NewClient(nil, false, nil, "m", "api")
+return c.ExecuteRequest(context.Background(), config.RequestSpec{
+Method:   http.MethodGet,
+URL:      server.URL + "/files/file.bin",
+Download: target,
+})
+}
+
+// checkDownloaded verifies that target holds fileData and that nothing of
+// the partial download is left
+func checkDownloaded(t *testing.T, target string) {
+t.Helper()
+data, err := os.ReadFile(target)
+if err != nil {
+t.Fatalf("reading download: %v", err)
+}
+if !bytes.Equal(data, fileData) {
+t.Errorf("downloaded %d bytes that differ from the %d served", len(data), len(fileData))
+}
+for _, leftover := range []string{target + partialSuffix, target + partialSuffix + validatorSuffix} {
+if _, err := os.Stat(leftover); err == nil {
+t.Errorf("%s was left behind", leftover)
+}
+}
+}
+
+func TestDownload(t *testing.T) {
+server := newDownloadServer(t, nil)
+dir := t.TempDir()
+
+// A directory target takes its file name from the URL
+result, err := downloadTo(server, dir+string(os.PathSeparator))
+if err != nil {
+t.Fatalf("ExecuteRequest: %v", err)
+}
+target := filepath.Join(dir, "file.bin")
+if want := "Saved 9.8 KiB to " + target; result != want {
+t.Errorf("result %q, want %q", result, want)
+}
+checkDownloaded(t, target)
+if got, want := server.request(), `Range="" If-Range=""`; got != want {
+t.Errorf("request %s, want %s", got, want)
+}
+}
+
+func TestDownloadResume(t *testing.T) {
+tests := []struct {
+name      string
+partial   []byte
+validator string
+request   string
+result    string
+}{
+{
+name:      "same version",
+partial:   fileData[:4000],
+validator: `"v1"`,
+request:   `Range="bytes=4000-" If-Range="\"v1\""`,
+result:    "(resumed after 3.9 KiB)",
+},
+{
+// The server sends the whole file instead of the rest
+name:      "changed since",
+partial:   []byte("stale data"),
+validator: `"v0"`,
+request:   `Range="bytes=10-" If-Range="\"v0\""`,
+},
+{
+// Without a validator the rest may belong to another version
+name:    "no validator",
+partial: []byte("stale data"),
+request: `Range="" If-Range=""`,
+},
+{
+// 416: the partial file already holds the whole body
+name:      "already complete",
+partial:   fileData,
+validator: `"v1"`,
+request:   `Range="bytes=10000-" If-Range="\"v1\""`,
+},
+}
+for _, tt := range tests {
+t.Run(tt.name, func(t *testing.T) {
+server := newDownloadServer(t, nil)
+target := filepath.Join(t.TempDir(), "out.bin")
+partialDownload(t, target, tt.partial, tt.validator)
+
+result, err := downloadTo(server, target)
+if err != nil {
+t.Fatalf("ExecuteRequest: %v", err)
+}
+if got := server.request(); got != tt.request {
+t.Errorf("request %s, want %s", got, tt.request)
+}
+if !strings.HasPrefix(result, "Saved ") || !strings.HasSuffix(result, tt.result) {
+t.Errorf("result %q, want it to end in %q", result, tt.result)
+}
+checkDownloaded(t, target)
+})
+}
+}
+
+func TestDownloadResumeErrors(t *testing.T) {
+tests := []struct {
+name    string
+handler http.HandlerFunc
+want    string
+}{
+{
+name: "416 for a file of another size",
+handler: func(w http.ResponseWriter, r *http.Request) {
+w.Header().Set("Content-Range", "bytes */99999")
+w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+},
+want: "the server cannot resume",
+},
+{
+name: "206 at another position",
+handler: func(w http.ResponseWriter, r *http.Request) {
+w.Header().Set("Content-Range", "bytes 0-9/10000")
+w.WriteHeader(http.StatusPartialContent)
+w.Write(fileData[:10])
+},
+want: `resumed .* at an unexpected position (Content-Range "bytes 0-9/10000")`,
+},
+}
+for _, tt := range tests {
+t.Run(tt.name, func(t *testing.T) {
+server := newDownloadServer(t, tt.handler)
+target := filepath.Join(t.TempDir(), "out.bin")
+partialDownload(t, target, fileData[:4000], `"v1"`)
+
+_, err := downloadTo(server, target)
+want := strings.ReplaceAll(tt.want, ".*", target+partialSuffix)
+if err == nil || !strings.Contains(err.Error(), want) {
+t.Errorf("ExecuteRequest error = %v, want it to contain %q", err, want)
+}
+// The partial download is kept to try again
+if data, err := os.ReadFile(target + partialSuffix); err != nil || len(data) != 4000 {
+t.Errorf("partial download holds %d bytes, %v; want it untouched", len(data), err)
+}
+if _, err := os.Stat(target); err == nil {
+t.Errorf("%s was created", target)
+}
+})
+}
+}
+
+func TestDownloadInterruptedKeepsValidator(t *testing.T) {
+server := newDownloadServer(t, func(w http.ResponseWriter, r *http.Request) {
+w.Header().Set("ETag", `"v1"`)
+w.Header().Set("Content-Length", fmt.Sprint(len(fileData)))
+w.Write(fileData[:4000])
+w.(http.Flusher).Flush()
+if conn, _, err := w.(http.Hijacker).Hijack(); err == nil {
+conn.Close()
+}
+})
+target := filepath.Join(t.TempDir(), "out.bin")
+
+if _, err := downloadTo(server, target); err == nil {
+t.Fatal("ExecuteRequest: want an error for the cut-off body")
+}
+if data, err := os.ReadFile(target + partialSuffix); err != nil || !bytes.Equal(data, fileData[:4000]) {
+t.Errorf("partial download holds %d bytes, %v; want the 4000 received", len(data), err)
+}
+if validator, err := os.ReadFile(target + partialSuffix + validatorSuffix); err != nil || string(validator) != `"v1"` {
+t.Errorf("validator %q, %v; want the ETag", validator, err)
+}
+}
+
+func TestDownloadDispositionName(t *testing.T) {
+server := newDownloadServer(t, func(w http.ResponseWriter, r *http.Request) {
+w.Header().Set("Content-Disposition", `attachment; filename="../report.csv"`)
+w.Write([]byte("a,b\n"))
+})
+dir := t.TempDir()
+
+if _, err := downloadTo(server, dir+string(os.PathSeparator)); err != nil {
+t.Fatalf("ExecuteRequest: %v", err)
+}
+// The name is stripped of its directories
+if data, err := os.ReadFile(filepath.Join(dir, "report.csv")); err != nil || string(data) != "a,b\n" {
+t.Errorf("report.csv holds %q, %v", data, err)
+}
+
+// A file of that name is not replaced
+_, err := downloadTo(server, dir+string(os.PathSeparator))
+if err == nil || !strings.Contains(err.Error(), "which already exists") {
+t.Errorf("second download error = %v, want a refusal", err)
+}
+}
+
+func TestContentRange(t *testing.T) {
+tests := []struct {
+value       string
+start, size int64
+ok          bool
+}{
+{"bytes 100-199/1000", 100, 1000, true},
+{"bytes 100-199/*", 100, -1, true},
+{"bytes */1000", -1, 1000, true},
+{"bytes x-1/1000", 0, 0, false},
+{"bytes 0-1", 0, 0, false},
+{"items 0-1/2", 0, 0, false},
+}
+for _, tt := range tests {
+start, size, ok := contentRange(tt.value)
+if start != tt.start || size != tt.size || ok != tt.ok {
+t.Errorf("contentRange(%q) = %d, %d, %v; want %d, %d, %v", tt.value, start, size, ok, tt.start, tt.size, tt.ok)
+}
+}
+}
+
+func TestResumeValidator(t *testing.T) {
+tests := []struct {
+etag, lastModified, want string
+}{
+{`"v1"`, "Mon, 02 Jan 2006 15:04:05 GMT", `"v1"`},
+{`W/"v1"`, "Mon, 02 Jan 2006 15:04:05 GMT", "Mon, 02 Jan 2006 15:04:05 GMT"},
+{`W/"v1"`, "", ""},
+{"", "", ""},
+}
+for _, tt := range tests {
+header := http.Header{}
+if tt.etag != "" {
+header.Set("ETag", tt.etag)
+}
+if tt.lastModified != "" {
+header.Set("Last-Modified", tt.lastModified)
+}
+if got := resumeValidator(header); got != tt.want {
+t.Errorf("resumeValidator(ETag %s, Last-Modified %q) = %q, want %q", tt.etag, tt.lastModified, got, tt.want)
+}
+}
+}
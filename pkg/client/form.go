@@ -0,0 +1,282 @@
+package client
+
+import (
+"fmt"
+"io"
+"mime"
+"mime/multipart"
+"net/http"
+"net/textproto"
+"os"
+"path/filepath"
+"sort"
+"strings"
+
+"github.com/zqtools/apicli/pkg/config"
+"github.com/zqtools/apicli/pkg/term"
+)
+
+// formPart is one part of a multipart form: a value, or a file whose
+// contents are read while the form is sent
+type formPart struct {
+field       string
+value       string
+path        string
+contentType string
+// size of the file, or -1 when it is not known in advance
+size int64
+}
+
+// createFormRequest builds a multipart request whose body is written through
+// a pipe as it is sent, so files are never held in memory. historyForm
+// receives a summary of every field.
+func (c *Client) createFormRequest(method, url string, form map[string]config.FormField, historyForm map[string]string) (*http.Request, error) {
+parts, err := c.formParts(form)
+if err != nil {
+return nil, err
+}
+boundary := multipart.NewWriter(nil).Boundary()
+
+for _, part := range parts {
+summary := part.value
+if part.path != "" {
+summary = "@" + part.path
+}
+if previous, ok := historyForm[part.field]; ok {
+summary = previous + ", " + summary
+}
+historyForm[part.field] = summary
+}
+
+// The length is known up front unless a file's size is not
+length, err := formLength(boundary, parts)
+if err != nil {
+return nil, err
+}
+
+// Uploading files can take a while, so do draws their progress as they are sent
+for _, part := range parts {
+if part.path != "" && term.IsTerminal(os.Stderr) {
+c.uploadProgress = true
+}
+}
+
+getBody := func() (io.ReadCloser, error) {
+reader, writer := io.Pipe()
+go func() {
+writer.CloseWithError(writeForm(writer, boundary, parts))
+}()
+return reader, nil
+}
+
+body, _ := getBody()
+req, err := http.NewRequest(method, url, body)
+if err != nil {
+body.Close()
+return nil, err
+}
+req.GetBody = getBody
+req.ContentLength = length
+req.Header.Set("Content-Type", "multipart/form-data; boundary="+boundary)
+return req, nil
+}
+
+// formParts renders the form's fields in name order. A field's value is
+// uploaded as a file only when it is a file parameter; file entries may be
+// glob patterns, each match becoming a part of the same field.
+func (c *Client) formParts(form map[string]config.FormField) ([]formPart, error) {
+names := make([]string, 0, len(form))
+for name := range form {
+names = append(names, name)
+}
+sort.Strings(names)
+
+var parts []formPart
+for _, name := range names {
+field := form[name]
+contentType, err := c.renderer.Render(field.ContentType)
+if err != nil {
+return nil, fmt.Errorf("rendering content type of form field '%s': %w", name, err)
+}
+
+if len(field.Files) == 0 {
+if file := c.fileValue(field.Value); file != nil {
+part, err := filePart(name, file.Path, contentType)
+if err != nil {
+return nil, err
+}
+parts = append(parts, part)
+continue
+}
+value, err := c.renderer.Render(field.Value)
+if err != nil {
+return nil, fmt.Errorf("rendering form field template: %w", err)
+}
+parts = append(parts, formPart{field: name, value: value, contentType: contentType})
+continue
+}
+
+for _, tmpl := range field.Files {
+paths, err := c.formFiles(name, tmpl)
+if err != nil {
+return nil, err
+}
+for _, path := range paths {
+part, err := filePart(name, path, contentType)
+if err != nil {
+return nil, err
+}
+parts = append(parts, part)
+}
+}
+}
+return parts, nil
+}
+
+// formFiles returns the files a file entry of a form field names: a file
+// parameter, a path or the regular files matching a glob pattern
+func (c *Client) formFiles(field, tmpl string) ([]string, error) {
+if file := c.fileValue(tmpl); file != nil {
+return []string{file.Path}, nil
+}
+pattern, err := c.renderer.Render(tmpl)
+if err != nil {
+return nil, fmt.Errorf("rendering form file path template: %w", err)
+}
+if !strings.ContainsAny(pattern, "*?[") {
+return []string{pattern}, nil
+}
+
+matches, err := filepath.Glob(pattern)
+if err != nil {
+return nil, fmt.Errorf("form field '%s': invalid pattern '%s': %w", field, pattern, err)
+}
+var files []string
+for _, match := range matches {
+if info, err := os.Stat(match); err == nil && !info.IsDir() {
+files = append(files, match)
+}
+}
+if len(files) == 0 {
+return nil, fmt.Errorf("form field '%s': no files match '%s'", field, pattern)
+}
+return files, nil
+}
+
+// filePart describes a file to upload, guessing its content type from the
+// extension unless one is given
+func filePart(field, path, contentType string) (formPart, error) {
+info, err := os.Stat(path)
+if err != nil {
+return formPart{}, fmt.Errorf("opening form file: %w", err)
+}
+if info.IsDir() {
+return formPart{}, fmt.Errorf("form field '%s': '%s' is a directory", field, path)
+}
+
+if contentType == "" {
+contentType = mime.TypeByExtension(filepath.Ext(path))
+}
+if contentType == "" {
+contentType = "application/octet-stream"
+}
+size := int64(-1)
+if info.Mode().IsRegular() {
+size = info.Size()
+}
+return formPart{field: field, path: path, contentType: contentType, size: size}, nil
+}
+
+// formLength returns the size of the encoded form, or -1 when a file's size
+// is not known. The part headers are encoded without the contents, whose
+// sizes are added instead.
+func formLength(boundary string, parts []formPart) (int64, error) {
+counter := &countingWriter{}
+writer := multipart.NewWriter(counter)
+if err := writer.SetBoundary(boundary); err != nil {
+return 0, err
+}
+for _, part := range parts {
+if _, err := writer.CreatePart(part.header()); err != nil {
+return 0, err
+}
+if part.path == "" {
+counter.n += int64(len(part.value))
+} else if part.size < 0 {
+return -1, nil
+} else {
+counter.n += part.size
+}
+}
+if err := writer.Close(); err != nil {
+return 0, err
+}
+return counter.n, nil
+}
+
+// writeForm encodes the form to w, reading each file as it goes
+func writeForm(w io.Writer, boundary string, parts []formPart) error {
+writer := multipart.NewWriter(w)
+if err := writer.SetBoundary(boundary); err != nil {
+return err
+}
+
+for _, part := range parts {
+dst, err := writer.CreatePart(part.header())
+if err != nil {
+return fmt.Errorf("creating form part: %w", err)
+}
+if part.path == "" {
+if _, err := io.WriteString(dst, part.value); err != nil {
+return fmt.Errorf("writing form field: %w", err)
+}
+continue
+}
+if err := copyFile(dst, part.path); err != nil {
+return err
+}
+}
+
+if err := writer.Close(); err != nil {
+return fmt.Errorf("closing multipart writer: %w", err)
+}
+return nil
+}
+
+func copyFile(dst io.Writer, path string) error {
+file, err := os.Open(path)
+if err != nil {
+return fmt.Errorf("opening form file: %w", err)
+}
+defer file.Close()
+if _, err := io.Copy(dst, file); err != nil {
+return fmt.Errorf("copying file content: %w", err)
+}
+return nil
+}
+
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+// header returns the MIME header of the part
+func (p formPart) header() textproto.MIMEHeader {
+header := make(textproto.MIMEHeader)
+disposition := fmt.Sprintf(`form-data; name="%s"`, quoteEscaper.Replace(p.field))
+if p.path != "" {
+disposition += fmt.Sprintf(`; filename="%s"`, quoteEscaper.Replace(filepath.Base(p.path)))
+}
+header.Set("Content-Disposition", disposition)
+if p.contentType != "" {
+header.Set("Content-Type", p.contentType)
+}
+return header
+}
+
+// countingWriter counts the bytes written to it
+type countingWriter struct {
+n int64
+}
+
+func (w *countingWriter) Write(b []byte) (int, error) {
+w.n += int64(len(b))
+return len(b), nil
+}
@@ -0,0 +1,165 @@
+package client
+
+import (
+"context"
+"io"
+"mime"
+"mime/multipart"
+"net/http"
+"net/http/httptest"
+"os"
+"path/filepath"
+"strings"
+"sync"
+"testing"
+
+"github.com/zqtools/apicli/pkg/config"
+)
+
+// formUpload is what the form server received in one request
+type formUpload struct {
+contentLength int64
+received      int64
+parts         []string
+}
+
+// newFormServer records every multipart request it receives, answering
+// the first failures of them with 503
+func newFormServer(t *testing.T, failures int) (*httptest.Server, func() []formUpload) {
+var mu sync.Mutex
+var uploads []formUpload
+server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+body, err := io.ReadAll(r.Body)
+if err != nil {
+t.Errorf("reading upload: %v", err)
+}
+upload := formUpload{contentLength: r.ContentLength, received: int64(len(body))}
+
+_, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+reader := multipart.NewReader(strings.NewReader(string(body)), params["boundary"])
+for {
+part, err := reader.NextPart()
+if err != nil {
+if err != io.EOF {
+t.Errorf("reading form: %v", err)
+}
+break
+}
+content, _ := io.ReadAll(part)
+upload.parts = append(upload.parts, part.FormName()+"|"+part.FileName()+"|"+part.Header.Get("Content-Type")+"|"+string(content))
+}
+
+mu.Lock()
+uploads = append(uploads, upload)
+n := len(uploads)
+mu.Unlock()
+if n <= failures {
+w.WriteHeader(http.StatusServiceUnavailable)
+}
+}))
+t.Cleanup(server.Close)
+return server, func() []formUpload {
+mu.Lock()
+defer mu.Unlock()
+return append([]formUpload(nil), uploads...)
+}
+}
+
+func TestFormContentLength(t *testing.T) {
+dir := t.TempDir()
+files := map[string]string{
+"a.txt":           "first file\n",
+"b.txt":           strings.Repeat("second file\n", 5000),
+`quote "x".json`:  `{"x": 1}`,
+"empty.bin":       "",
+}
+for name, content := range files {
+if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+t.Fatal(err)
+}
+}
+
+server, uploads := newFormServer(t, 1)
+retries := 1
+c := NewClient(map[string]interface{}{"title": "Ünïcode & \"quotes\""}, false, nil, "m", "api")
+_, err := c.ExecuteRequest(context.Background(), config.RequestSpec{
+Method: http.MethodPut,
+URL:    server.URL,
+Form: map[string]config.FormField{
+"title":    {Value: "${title}"},
+"notes":    {Value: "plain", ContentType: "text/markdown"},
+"docs":     {Files: []string{filepath.Join(dir, "*.txt")}},
+`meta "1"`: {Files: []string{filepath.Join(dir, `quote "x".json`), filepath.Join(dir, "empty.bin")}},
+},
+Retries: &retries,
+Backoff: "1ms",
+})
+if err != nil {
+t.Fatalf("ExecuteRequest: %v", err)
+}
+
+// The retry sends the whole form again
+got := uploads()
+if len(got) != 2 {
+t.Fatalf("%d uploads, want 2", len(got))
+}
+for i, upload := range got {
+if upload.contentLength != upload.received {
+t.Errorf("upload %d: Content-Length %d, but %d bytes were sent", i+1, upload.contentLength, upload.received)
+}
+}
+
+want := []string{
+"docs|a.txt|text/plain; charset=utf-8|" + files["a.txt"],
+"docs|b.txt|text/plain; charset=utf-8|" + files["b.txt"],
+`meta "1"|quote "x".json|application/json|` + files[`quote "x".json`],
+`meta "1"|empty.bin|application/octet-stream|`,
+"notes||text/markdown|plain",
+"title|||Ünïcode & \"quotes\"",
+}
+if strings.Join(got[1].parts, "\n") != strings.Join(want, "\n") {
+t.Errorf("parts:\n%s\nwant:\n%s", strings.Join(got[1].parts, "\n"), strings.Join(want, "\n"))
+}
+}
+
+func TestFormLengthOfUnknownSize(t *testing.T) {
+parts := []formPart{
+{field: "a", value: "x"},
+{field: "b", path: os.DevNull, size: -1},
+}
+length, err := formLength("boundary", parts)
+if err != nil || length != -1 {
+t.Errorf("formLength = %d, %v; want -1", length, err)
+}
+}
+
+func TestFormLengthMatchesWriteForm(t *testing.T) {
+file := filepath.Join(t.TempDir(), "data.bin")
+if err := os.WriteFile(file, []byte(strings.Repeat("x", 12345)), 0644); err != nil {
+t.Fatal(err)
+}
+part, err := filePart("upload", file, "")
+if err != nil {
+t.Fatalf("filePart: %v", err)
+}
+
+tests := [][]formPart{
+nil,
+{{field: "a", value: ""}},
+{{field: "a", value: "x"}, {field: "é\"\\", value: "ü", contentType: "text/plain"}},
+{part, {field: "a", value: "x"}, part},
+}
+for i, parts := range tests {
+length, err := formLength("test-boundary", parts)
+if err != nil {
+t.Fatalf("formLength: %v", err)
+}
+counter := &countingWriter{}
+if err := writeForm(counter, "test-boundary", parts); err != nil {
+t.Fatalf("writeForm: %v", err)
+}
+if length != counter.n {
+t.Errorf("form %d: formLength %d, writeForm wrote %d", i, length, counter.n)
+}
+}
+}
@@ -0,0 +1,277 @@
+package client
+
+import (
+"context"
+"errors"
+"fmt"
+"io"
+"math/rand"
+"net"
+"net/http"
+"net/url"
+"strconv"
+"sync"
+"time"
+
+"github.com/zqtools/apicli/pkg/config"
+)
+
+// maxDrain bounds how much of a failed attempt's response is read so the
+// connection can be reused
+const maxDrain = 64 << 10
+
+// send executes req under the retry policy and returns the final response
+// and the number of attempts made. Requests whose body cannot be replayed
+// are sent once.
+func (c *Client) send(req *http.Request, policy *config.RetryPolicy) (*http.Response, int, error) {
+replayable := req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+
+for attempt := 1; ; attempt++ {
+attemptReq := req
+if attempt > 1 {
+var err error
+if attemptReq, err = cloneRequest(req); err != nil {
+return nil, attempt - 1, err
+}
+}
+if c.verbose && policy.Retries > 0 {
+fmt.Printf("Attempt %d of %d: %s %s\n", attempt, policy.Retries+1, req.Method, c.redactor.String(req.URL.String()))
+}
+
+start := time.Now()
+resp, err := c.do(attemptReq, policy.Timeout)
+reason := retryReason(policy, resp, err)
+if reason == "" || attempt > policy.Retries || !replayable {
+return resp, attempt, err
+}
+
+delay := backoffDelay(policy, attempt, resp)
+if c.verbose {
+fmt.Printf("Attempt %d failed after %s (%s), retrying in %s\n", attempt, time.Since(start).Round(time.Millisecond), reason, delay.Round(time.Millisecond))
+}
+if resp != nil {
+io.CopyN(io.Discard, resp.Body, maxDrain)
+resp.Body.Close()
+}
+timer := time.NewTimer(delay)
+select {
+case <-timer.C:
+case <-req.Context().Done():
+timer.Stop()
+return nil, attempt, req.Context().Err()
+}
+}
+}
+
+// do sends a single attempt of req. The timeout covers connecting, sending
+// the request and reading the whole response body, unless the body is
+// streamed and its timer stopped with disarmTimeout. It is measured from
+// the last read of the request body.
+func (c *Client) do(req *http.Request, timeout time.Duration) (*http.Response, error) {
+if c.uploadProgress && req.Body != nil && req.Body != http.NoBody {
+// Each attempt draws its own bar, for the body it actually sends
+req = req.WithContext(req.Context())
+req.Body = &uploadBody{ReadCloser: req.Body, bar: &progress{name: "upload", total: req.ContentLength, start: time.Now()}}
+}
+if timeout <= 0 {
+return c.httpClient.Do(req)
+}
+
+ctx, cancel := context.WithCancelCause(req.Context())
+timer := time.AfterFunc(timeout, func() {
+cancel(&timeoutError{after: timeout})
+})
+req = req.WithContext(ctx)
+if req.Body != nil && req.Body != http.NoBody {
+// Large uploads can take longer than the timeout, so it restarts for as
+// long as the body is being sent
+req.Body = &sendingBody{ReadCloser: req.Body, timer: timer, timeout: timeout}
+}
+resp, err := c.httpClient.Do(req)
+if err != nil {
+timer.Stop()
+err = timedOut(ctx, err)
+cancel(nil)
+return nil, err
+}
+resp.Body = &timedBody{ReadCloser: resp.Body, ctx: ctx, timer: timer, cancel: cancel}
+return resp, nil
+}
+
+// timeoutError is the cause of a request cancelled by its timeout
+type timeoutError struct {
+after time.Duration
+}
+
+func (e *timeoutError) Error() string {
+return fmt.Sprintf("timed out after %s", e.after)
+}
+
+// Timeout and Temporary make a timeout a net.Error, so it is retried like
+// other connection errors
+func (e *timeoutError) Timeout() bool   { return true }
+func (e *timeoutError) Temporary() bool { return true }
+
+// timedOut replaces the context error of a request cancelled by its timeout
+// with the timeout itself
+func timedOut(ctx context.Context, err error) error {
+var timeout *timeoutError
+if !errors.As(context.Cause(ctx), &timeout) {
+return err
+}
+var urlErr *url.Error
+if errors.As(err, &urlErr) {
+return &url.Error{Op: urlErr.Op, URL: urlErr.URL, Err: timeout}
+}
+return timeout
+}
+
+// sendingBody is a request body that restarts the request timeout whenever
+// more of it is read
+type sendingBody struct {
+io.ReadCloser
+timer   *time.Timer
+timeout time.Duration
+}
+
+func (b *sendingBody) Read(p []byte) (int, error) {
+b.timer.Reset(b.timeout)
+return b.ReadCloser.Read(p)
+}
+
+// uploadBody is a request body whose progress is drawn as it is sent
+type uploadBody struct {
+io.ReadCloser
+bar  *progress
+once sync.Once
+}
+
+func (b *uploadBody) Read(p []byte) (int, error) {
+n, err := b.ReadCloser.Read(p)
+b.bar.Write(p[:n])
+if err == io.EOF {
+b.once.Do(b.bar.finish)
+}
+return n, err
+}
+
+func (b *uploadBody) Close() error {
+b.once.Do(b.bar.finish)
+return b.ReadCloser.Close()
+}
+
+// timedBody is a response body read under the request timeout
+type timedBody struct {
+io.ReadCloser
+ctx    context.Context
+timer  *time.Timer
+cancel context.CancelCauseFunc
+}
+
+func (b *timedBody) Read(p []byte) (int, error) {
+n, err := b.ReadCloser.Read(p)
+if err != nil && err != io.EOF {
+err = timedOut(b.ctx, err)
+}
+return n, err
+}
+
+func (b *timedBody) Close() error {
+b.timer.Stop()
+err := b.ReadCloser.Close()
+b.cancel(nil)
+return err
+}
+
+// disarmTimeout stops the timeout of a response whose body is streamed for
+// as long as the server keeps it open
+func disarmTimeout(resp *http.Response) {
+if body, ok := resp.Body.(*timedBody); ok {
+body.timer.Stop()
+}
+}
+
+// retryReason describes why an attempt should be retried, or returns ""
+func retryReason(policy *config.RetryPolicy, resp *http.Response, err error) string {
+if err != nil {
+if policy.RetryErrors() && isConnectionError(err) {
+return err.Error()
+}
+return ""
+}
+if policy.RetryStatus(resp.StatusCode) {
+return resp.Status
+}
+return ""
+}
+
+// isConnectionError reports whether err happened while connecting or
+// talking to the server, as opposed to building the request
+func isConnectionError(err error) bool {
+// Every error from the HTTP client is a *url.Error; look at its cause
+var urlErr *url.Error
+if errors.As(err, &urlErr) {
+err = urlErr.Err
+}
+var netErr net.Error
+var opErr *net.OpError
+return errors.As(err, &netErr) || errors.As(err, &opErr) ||
+errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// backoffDelay returns how long to wait before the next attempt: the
+// server's Retry-After when given, otherwise exponential backoff from the
+// policy's base delay with jitter. Either is capped at the policy's maximum.
+func backoffDelay(policy *config.RetryPolicy, attempt int, resp *http.Response) time.Duration {
+if resp != nil {
+if delay, ok := retryAfter(resp.Header.Get("Retry-After")); ok {
+if delay > policy.MaxBackoff {
+delay = policy.MaxBackoff
+}
+return delay
+}
+}
+
+delay := policy.Backoff
+for i := 1; i < attempt && delay < policy.MaxBackoff; i++ {
+delay *= 2
+}
+if delay > policy.MaxBackoff {
+delay = policy.MaxBackoff
+}
+// Wait between half and all of the delay, so clients retrying together spread out
+if half := int64(delay / 2); half > 0 {
+delay = time.Duration(half + rand.Int63n(half+1))
+}
+return delay
+}
+
+// retryAfter parses a Retry-After header given in seconds or as an HTTP date
+func retryAfter(value string) (time.Duration, bool) {
+if value == "" {
+return 0, false
+}
+if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+return time.Duration(seconds) * time.Second, true
+}
+if at, err := http.ParseTime(value); err == nil {
+if delay := time.Until(at); delay > 0 {
+return delay, true
+}
+return 0, true
+}
+return 0, false
+}
+
+// cloneRequest copies req with a fresh body for another attempt
+func cloneRequest(req *http.Request) (*http.Request, error) {
+clone := req.Clone(req.Context())
+if req.GetBody != nil {
+body, err := req.GetBody()
+if err != nil {
+return nil, fmt.Errorf("replaying request body: %w", err)
+}
+clone.Body = body
+}
+return clone, nil
+}
@@ -0,0 +1,198 @@
+package client
+
+import (
+"context"
+"net/http"
+"net/http/httptest"
+"strings"
+"sync"
+"testing"
+"time"
+
+"github.com/zqtools/apicli/pkg/config"
+)
+
+// retryPolicy resolves the retry policy of a GET with the given settings
+func retryPolicy(t *testing.T, retries int, backoff, maxBackoff string) *config.RetryPolicy {
+t.Helper()
+spec := config.RequestSpec{Method: http.MethodGet, Retries: &retries, Backoff: backoff, MaxBackoff: maxBackoff}
+policy, err := spec.RetryPolicy()
+if err != nil {
+t.Fatalf("RetryPolicy: %v", err)
+}
+return policy
+}
+
+func TestBackoffDelay(t *testing.T) {
+policy := retryPolicy(t, 10, "100ms", "1s")
+
+// Each delay is jittered between half and all of the exponential step
+tests := []struct {
+attempt int
+max     time.Duration
+}{
+{1, 100 * time.Millisecond},
+{2, 200 * time.Millisecond},
+{4, 800 * time.Millisecond},
+{5, time.Second},
+{30, time.Second},
+}
+for _, tt := range tests {
+for i := 0; i < 20; i++ {
+if delay := backoffDelay(policy, tt.attempt, nil); delay < tt.max/2 || delay > tt.max {
+t.Errorf("attempt %d: delay %s, want between %s and %s", tt.attempt, delay, tt.max/2, tt.max)
+}
+}
+}
+}
+
+func TestBackoffDelayRetryAfter(t *testing.T) {
+policy := retryPolicy(t, 3, "100ms", "10s")
+tests := []struct {
+retryAfter string
+want       time.Duration
+}{
+{"2", 2 * time.Second},
+{"0", 0},
+// The server's delay is capped at max_backoff
+{"3600", 10 * time.Second},
+{time.Now().Add(time.Hour).UTC().Format(http.TimeFormat), 10 * time.Second},
+{time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat), 0},
+}
+for _, tt := range tests {
+resp := &http.Response{Header: http.Header{"Retry-After": {tt.retryAfter}}}
+if delay := backoffDelay(policy, 1, resp); delay != tt.want {
+t.Errorf("Retry-After %q: delay %s, want %s", tt.retryAfter, delay, tt.want)
+}
+}
+
+// A Retry-After that is neither seconds nor a date falls back to backoff
+resp := &http.Response{Header: http.Header{"Retry-After": {"soon"}}}
+if delay := backoffDelay(policy, 1, resp); delay < 50*time.Millisecond || delay > 100*time.Millisecond {
+t.Errorf("Retry-After \"soon\": delay %s, want the backoff", delay)
+}
+}
+
+// flakyServer fails the first failures requests to it by calling fail, then
+// answers 200
+func flakyServer(t *testing.T, failures int, fail func(w http.ResponseWriter)) (*httptest.Server, func() int) {
+var mu sync.Mutex
+requests := 0
+server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+mu.Lock()
+requests++
+n := requests
+mu.Unlock()
+if n <= failures {
+fail(w)
+return
+}
+w.Write([]byte("ok"))
+}))
+t.Cleanup(server.Close)
+return server, func() int {
+mu.Lock()
+defer mu.Unlock()
+return requests
+}
+}
+
+func TestSendRetries(t *testing.T) {
+unavailable := func(w http.ResponseWriter) {
+// Far longer than the test runs, so only the max_backoff cap lets it pass
+w.Header().Set("Retry-After", "3600")
+w.WriteHeader(http.StatusServiceUnavailable)
+}
+hangUp := func(w http.ResponseWriter) {
+conn, _, err := w.(http.Hijacker).Hijack()
+if err == nil {
+conn.Close()
+}
+}
+
+tests := []struct {
+name     string
+fail     func(w http.ResponseWriter)
+method   string
+retryOn  []string
+requests int
+body     string
+}{
+{"503 until it succeeds", unavailable, http.MethodGet, nil, 3, "ok"},
+{"connection errors", hangUp, http.MethodGet, nil, 3, "ok"},
+{"status not retried", unavailable, http.MethodGet, []string{"502"}, 1, ""},
+{"post without retry_on", unavailable, http.MethodPost, nil, 1, ""},
+{"post with retry_on", unavailable, http.MethodPost, []string{"5xx"}, 3, "ok"},
+}
+for _, tt := range tests {
+t.Run(tt.name, func(t *testing.T) {
+server, requests := flakyServer(t, 2, tt.fail)
+retries := 5
+c := NewClient(nil, false, nil, "m", "api")
+result, err := c.ExecuteRequest(context.Background(), config.RequestSpec{
+Method:     tt.method,
+URL:        server.URL,
+Retries:    &retries,
+RetryOn:    tt.retryOn,
+Backoff:    "1ms",
+MaxBackoff: "10ms",
+})
+if err != nil {
+t.Fatalf("ExecuteRequest: %v", err)
+}
+if got := requests(); got != tt.requests {
+t.Errorf("%d requests, want %d", got, tt.requests)
+}
+if result != tt.body {
+t.Errorf("result %q, want %q", result, tt.body)
+}
+})
+}
+}
+
+func TestSendGivesUpAfterRetries(t *testing.T) {
+server, requests := flakyServer(t, 10, func(w http.ResponseWriter) {
+w.WriteHeader(http.StatusBadGateway)
+})
+retries := 2
+c := NewClient(nil, false, nil, "m", "api")
+_, err := c.ExecuteRequest(context.Background(), config.RequestSpec{
+Method:  http.MethodGet,
+URL:     server.URL,
+Retries: &retries,
+Backoff: "1ms",
+})
+if err != nil {
+t.Fatalf("ExecuteRequest: %v", err)
+}
+if got := requests(); got != 3 {
+t.Errorf("%d requests, want 3", got)
+}
+}
+
+func TestSendStopsWaitingWhenCancelled(t *testing.T) {
+server, requests := flakyServer(t, 10, func(w http.ResponseWriter) {
+w.WriteHeader(http.StatusServiceUnavailable)
+})
+retries := 5
+ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+defer cancel()
+
+start := time.Now()
+c := NewClient(nil, false, nil, "m", "api")
+_, err := c.ExecuteRequest(ctx, config.RequestSpec{
+Method:  http.MethodGet,
+URL:     server.URL,
+Retries: &retries,
+Backoff: "1h",
+})
+if err == nil || !strings.Contains(err.Error(), "context deadline exceeded") {
+t.Errorf("ExecuteRequest error = %v, want the context's", err)
+}
+if elapsed := time.Since(start); elapsed > 5*time.Second {
+t.Errorf("returned after %s, want it to stop waiting", elapsed)
+}
+if got := requests(); got != 1 {
+t.Errorf("%d requests, want 1", got)
+}
+}
@@ -0,0 +1,223 @@
+package client
+
+import (
+"bufio"
+"bytes"
+"encoding/json"
+"fmt"
+"io"
+"mime"
+"net/http"
+"os"
+"strings"
+
+"github.com/zqtools/apicli/pkg/config"
+)
+
+// Bounds on the transcript of a streamed response kept in history
+const (
+maxTranscriptEvents = 100
+maxTranscriptBytes  = 64 << 10
+)
+
+// streamMode says how a streamed response body is split up for printing
+type streamMode int
+
+const (
+// streamRaw prints the body as it is received
+streamRaw streamMode = iota + 1
+// streamEvents prints each server-sent event
+streamEvents
+// streamLines prints each line as a JSON document
+streamLines
+)
+
+// streamModeOf returns how the body of resp is streamed, or 0 when it is
+// read in full and printed once complete
+func streamModeOf(spec config.RequestSpec, resp *http.Response) streamMode {
+mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+switch mediaType {
+case "text/event-stream":
+return streamEvents
+case "application/x-ndjson", "application/ndjson", "application/jsonl", "application/x-jsonlines":
+return streamLines
+}
+
+isJSON := isJSONResponse(resp.Header)
+switch {
+case spec.Stream && isJSON:
+// JSON streamed under a plain JSON type is usually one document per line
+return streamLines
+case spec.Stream:
+return streamRaw
+case isChunked(resp) && !isJSON:
+// A chunked JSON document is buffered so it can be pretty-printed whole
+return streamRaw
+}
+return 0
+}
+
+func isChunked(resp *http.Response) bool {
+for _, encoding := range resp.TransferEncoding {
+if encoding == "chunked" {
+return true
+}
+}
+return false
+}
+
+// streamResponse prints the body of resp as it arrives and returns the
+// transcript kept for history. The transcript holds what was received even
+// when reading fails part way.
+func (c *Client) streamResponse(resp *http.Response, mode streamMode) (*transcript, error) {
+// The stream stays open for as long as the server sends it
+disarmTimeout(resp)
+
+t := &transcript{}
+reader := bufio.NewReader(resp.Body)
+var err error
+switch mode {
+case streamEvents:
+err = streamEventsTo(os.Stdout, reader, t)
+case streamLines:
+err = streamLinesTo(os.Stdout, reader, t)
+default:
+err = streamRawTo(os.Stdout, reader, t)
+}
+if err != nil {
+return t, fmt.Errorf("reading response stream: %w", err)
+}
+return t, nil
+}
+
+// streamEventsTo prints server-sent events: the event name and ID when
+// present, then the data pretty-printed if it is JSON
+func streamEventsTo(w io.Writer, r *bufio.Reader, t *transcript) error {
+var event, id string
+var data []string
+dispatch := func() {
+if len(data) > 0 {
+var b strings.Builder
+if event != "" {
+fmt.Fprintf(&b, "event: %s\n", event)
+}
+if id != "" {
+fmt.Fprintf(&b, "id: %s\n", id)
+}
+b.WriteString(prettyJSON(strings.Join(data, "\n")))
+b.WriteString("\n\n")
+io.WriteString(w, b.String())
+t.add(b.String())
+}
+event, id, data = "", "", nil
+}
+
+for {
+line, err := r.ReadString('\n')
+line = strings.TrimRight(line, "\r\n")
+switch {
+case line == "":
+dispatch()
+case strings.HasPrefix(line, ":"):
+// Comments are used as keep-alives
+default:
+field, value, _ := strings.Cut(line, ":")
+value = strings.TrimPrefix(value, " ")
+switch field {
+case "event":
+event = value
+case "data":
+data = append(data, value)
+case "id":
+id = value
+}
+}
+if err != nil {
+dispatch()
+return endOfStream(err)
+}
+}
+}
+
+// streamLinesTo prints each non-empty line, pretty-printed if it is JSON
+func streamLinesTo(w io.Writer, r *bufio.Reader, t *transcript) error {
+for {
+line, err := r.ReadString('\n')
+if line = strings.TrimSpace(line); line != "" {
+out := prettyJSON(line) + "\n"
+io.WriteString(w, out)
+t.add(out)
+}
+if err != nil {
+return endOfStream(err)
+}
+}
+}
+
+// streamRawTo copies the body to w as each read returns
+func streamRawTo(w io.Writer, r io.Reader, t *transcript) error {
+buf := make([]byte, 32<<10)
+for {
+n, err := r.Read(buf)
+if n > 0 {
+w.Write(buf[:n])
+t.addRaw(buf[:n])
+}
+if err != nil {
+return endOfStream(err)
+}
+}
+}
+
+func endOfStream(err error) error {
+if err == io.EOF {
+return nil
+}
+return err
+}
+
+// prettyJSON indents s if it is a JSON document and returns it unchanged
+// otherwise
+func prettyJSON(s string) string {
+var pretty bytes.Buffer
+if err := json.Indent(&pretty, []byte(s), "", "  "); err == nil {
+return pretty.String()
+}
+return s
+}
+
+// transcript keeps the start of a streamed response, up to
+// maxTranscriptEvents events and maxTranscriptBytes bytes
+type transcript struct {
+buf    bytes.Buffer
+events int
+kept   int
+// truncated is set once raw output no longer fits
+truncated bool
+}
+
+func (t *transcript) add(event string) {
+t.events++
+if t.kept < maxTranscriptEvents && t.buf.Len()+len(event) <= maxTranscriptBytes {
+t.buf.WriteString(event)
+t.kept++
+}
+}
+
+func (t *transcript) addRaw(chunk []byte) {
+if room := maxTranscriptBytes - t.buf.Len(); len(chunk) > room {
+chunk = chunk[:room]
+t.truncated = true
+}
+t.buf.Write(chunk)
+}
+
+func (t *transcript) String() string {
+switch {
+case t.kept < t.events:
+return fmt.Sprintf("%s[transcript truncated: %d of %d events recorded]\n", t.buf.String(), t.kept, t.events)
+case t.truncated:
+return fmt.Sprintf("%s\n[transcript truncated after %d bytes]\n", t.buf.String(), t.buf.Len())
+}
+return t.buf.String()
+}
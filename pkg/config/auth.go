@@ -0,0 +1,100 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// hmacAlgorithms lists the hash functions accepted for hmac signing
+var hmacAlgorithms = map[string]bool{
+	"sha1":   true,
+	"sha256": true,
+	"sha512": true,
+}
+
+// Check verifies that the auth block sets what its type needs
+func (a *AuthConfig) Check() error {
+	var missing []string
+	need := func(value, name string) {
+		if value == "" {
+			missing = append(missing, name)
+		}
+	}
+
+	switch a.Type {
+	case "", "none":
+	case "bearer":
+		need(a.Token, "token")
+	case "basic":
+		need(a.Username, "username")
+	case "apikey":
+		need(a.Key, "key")
+		need(a.Name, "name")
+		if a.In != "" && a.In != "header" && a.In != "query" {
+			return fmt.Errorf("apikey 'in' must be header or query, not '%s'", a.In)
+		}
+	case "hmac":
+		need(a.Secret, "secret")
+		if a.Algorithm != "" && !hmacAlgorithms[a.Algorithm] {
+			return fmt.Errorf("unknown hmac algorithm '%s' (expected sha1, sha256 or sha512)", a.Algorithm)
+		}
+	case "aws-sigv4":
+		need(a.AccessKey, "access_key")
+		need(a.SecretKey, "secret_key")
+		need(a.Region, "region")
+		need(a.Service, "service")
+	case "oauth2":
+		need(a.TokenURL, "token_url")
+		need(a.ClientID, "client_id")
+		switch a.GrantType {
+		case "", "client_credentials":
+		case "refresh_token":
+			need(a.RefreshToken, "refresh_token")
+		default:
+			return fmt.Errorf("unknown oauth2 grant_type '%s' (expected client_credentials or refresh_token)", a.GrantType)
+		}
+		if a.ClientAuth != "" && a.ClientAuth != "basic" && a.ClientAuth != "body" {
+			return fmt.Errorf("oauth2 client_auth must be basic or body, not '%s'", a.ClientAuth)
+		}
+	default:
+		return fmt.Errorf("unknown auth type '%s'", a.Type)
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("%s auth requires %s", a.Type, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// templateFields returns the settings of the auth block that may contain
+// templates
+func (a *AuthConfig) templateFields() []*string {
+	fields := []*string{
+		&a.Token, &a.Username, &a.Password,
+		&a.Key, &a.Name,
+		&a.Secret, &a.Header, &a.KeyID,
+		&a.AccessKey, &a.SecretKey, &a.SessionToken, &a.Region, &a.Service,
+		&a.TokenURL, &a.ClientID, &a.ClientSecret, &a.RefreshToken, &a.Audience,
+	}
+	for i := range a.Scopes {
+		fields = append(fields, &a.Scopes[i])
+	}
+	return fields
+}
+
+// Render returns a copy of the auth block with every template rendered
+func (a *AuthConfig) Render(render func(string) (string, error)) (*AuthConfig, error) {
+	rendered := *a
+	rendered.Scopes = append([]string(nil), a.Scopes...)
+	for _, field := range rendered.templateFields() {
+		if *field == "" {
+			continue
+		}
+		value, err := render(*field)
+		if err != nil {
+			return nil, err
+		}
+		*field = value
+	}
+	return &rendered, nil
+}
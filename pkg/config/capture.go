@@ -0,0 +1,56 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/zqtools/apicli/pkg/vars"
+	"gopkg.in/yaml.v3"
+)
+
+// captureRoots lists the parts of a response a capture path can start from
+var captureRoots = []string{"$.body", "$.headers", "$.status"}
+
+// UnmarshalYAML decodes a capture, which is either a path or a mapping
+func (c *CaptureSpec) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		c.Path = node.Value
+		return nil
+	}
+	type plain CaptureSpec
+	return node.Decode((*plain)(c))
+}
+
+// Check verifies the capture's path and TTL
+func (c CaptureSpec) Check() error {
+	if err := vars.CheckPath(c.Path); err != nil {
+		return err
+	}
+	found := false
+	for _, root := range captureRoots {
+		if c.Path == root || strings.HasPrefix(c.Path, root+".") || strings.HasPrefix(c.Path, root+"[") {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return fmt.Errorf("path '%s' must start with %s", c.Path, strings.Join(captureRoots, ", "))
+	}
+	if _, err := c.TTLDuration(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// TTLDuration returns how long the captured value stays valid, or 0 for no limit
+func (c CaptureSpec) TTLDuration() (time.Duration, error) {
+	if c.TTL == "" {
+		return 0, nil
+	}
+	ttl, err := time.ParseDuration(c.TTL)
+	if err != nil {
+		return 0, fmt.Errorf("invalid ttl '%s': %w", c.TTL, err)
+	}
+	return ttl, nil
+}
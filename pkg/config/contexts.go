@@ -0,0 +1,97 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// LoadContexts loads the contexts file at path, or returns an empty set of
+// contexts when it does not exist yet
+func LoadContexts(path string) (*Contexts, error) {
+	contexts := &Contexts{path: path}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			contexts.Contexts = make(map[string]Context)
+			return contexts, nil
+		}
+		return nil, fmt.Errorf("reading contexts: %w", err)
+	}
+
+	if err := yaml.Unmarshal(data, contexts); err != nil {
+		return nil, fmt.Errorf("parsing contexts: %w", err)
+	}
+	if contexts.Contexts == nil {
+		contexts.Contexts = make(map[string]Context)
+	}
+	return contexts, nil
+}
+
+// Save writes the contexts back to the file they were loaded from
+func (c *Contexts) Save() error {
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return fmt.Errorf("serializing contexts: %w", err)
+	}
+
+	// Contexts often hold tokens, so keep the file private to the user
+	if err := os.WriteFile(c.path, data, 0600); err != nil {
+		return fmt.Errorf("writing contexts: %w", err)
+	}
+	if err := os.Chmod(c.path, 0600); err != nil {
+		return fmt.Errorf("setting contexts file permissions: %w", err)
+	}
+	return nil
+}
+
+// Get returns the named context
+func (c *Contexts) Get(name string) (*Context, error) {
+	context, ok := c.Contexts[name]
+	if !ok {
+		return nil, fmt.Errorf("context '%s' not found (available: %s)", name, strings.Join(c.Names(), ", "))
+	}
+	return &context, nil
+}
+
+// Names returns the names of all contexts in order
+func (c *Contexts) Names() []string {
+	names := make([]string, 0, len(c.Contexts))
+	for name := range c.Contexts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Value returns the context's value for a parameter of an API below
+// modulePath. A key qualified with a longer module path wins over a shorter
+// one, and both win over the bare parameter name.
+func (c *Context) Value(modulePath []string, param string) (string, bool) {
+	if c == nil {
+		return "", false
+	}
+	for i := len(modulePath); i >= 0; i-- {
+		key := param
+		if i > 0 {
+			key = strings.Join(modulePath[:i], ".") + "." + param
+		}
+		if value, ok := c.Values[key]; ok {
+			return value, true
+		}
+	}
+	return "", false
+}
+
+// ParseAssignment splits a "key=value" argument
+func ParseAssignment(arg string) (string, string, error) {
+	key, value, ok := strings.Cut(arg, "=")
+	if !ok || key == "" {
+		return "", "", fmt.Errorf("expected key=value, got '%s'", arg)
+	}
+	return key, value, nil
+}
@@ -0,0 +1,98 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ConfigEnvVar names the environment variable that points at the API configuration
+const ConfigEnvVar = "APICLI_CONFIG"
+
+// projectConfigNames lists the file names searched for in each directory,
+// in order of preference
+var projectConfigNames = []string{".apicli.yaml", "apis.yaml"}
+
+// DiscoverConfig locates the API configuration file. The first match wins:
+// the explicit path (from --config), the APICLI_CONFIG environment variable,
+// a project file found by walking up from the working directory, and finally
+// the paths listed in the user configuration, resolved against apiDir.
+func DiscoverConfig(explicit string, userConfig *UserConfig, apiDir string) (string, error) {
+	if explicit != "" {
+		return explicit, nil
+	}
+
+	if envPath := os.Getenv(ConfigEnvVar); envPath != "" {
+		return envPath, nil
+	}
+
+	searched := []string{}
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		return "", fmt.Errorf("getting working directory: %w", err)
+	}
+	if path, ok := findProjectConfig(cwd); ok {
+		return path, nil
+	}
+	searched = append(searched, fmt.Sprintf("%s in %s and its parents", strings.Join(projectConfigNames, "/"), cwd))
+
+	if userConfig != nil {
+		for _, path := range userConfig.ConfigPaths(apiDir) {
+			if _, err := os.Stat(path); err == nil {
+				return path, nil
+			}
+			searched = append(searched, path)
+		}
+	}
+
+	return "", fmt.Errorf("no API configuration found (searched %s); use --config or %s", strings.Join(searched, ", "), ConfigEnvVar)
+}
+
+// findProjectConfig walks up from dir looking for a project configuration file
+func findProjectConfig(dir string) (string, bool) {
+	for {
+		for _, name := range projectConfigNames {
+			path := filepath.Join(dir, name)
+			if info, err := os.Stat(path); err == nil && !info.IsDir() {
+				return path, true
+			}
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", false
+		}
+		dir = parent
+	}
+}
+
+// ConfigPaths returns the API configuration paths listed in the user
+// configuration, with relative paths resolved against baseDir
+func (u *UserConfig) ConfigPaths(baseDir string) []string {
+	var paths []string
+	if u.APIConfigPath != "" {
+		paths = append(paths, u.APIConfigPath)
+	}
+	paths = append(paths, u.APIConfigPaths...)
+
+	for i, path := range paths {
+		paths[i] = u.ResolvePath(baseDir, path)
+	}
+	return paths
+}
+
+// ResolvePath resolves a path from the user configuration against baseDir,
+// expanding a leading "~/" to the user's home directory
+func (u *UserConfig) ResolvePath(baseDir, path string) string {
+	if strings.HasPrefix(path, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, path[2:])
+		}
+	}
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(baseDir, path)
+}
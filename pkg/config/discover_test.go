@@ -0,0 +1,99 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// chdir changes the working directory for the rest of the test
+func chdir(t *testing.T, dir string) {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+func TestDiscoverConfig(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"project/apis.yaml":           "",
+		"project/sub/deeper/file.txt": "",
+		"dotted/.apicli.yaml":         "",
+		"dotted/apis.yaml":            "",
+		"home/.api/shared.yaml":       "",
+		"empty/file.txt":              "",
+	})
+	apiDir := filepath.Join(dir, "home", ".api")
+	userConfig := &UserConfig{APIConfigPaths: []string{"missing.yaml", "shared.yaml"}}
+
+	tests := []struct {
+		name     string
+		explicit string
+		env      string
+		cwd      string
+		want     string
+	}{
+		{"explicit path", "other.yaml", "env.yaml", "project", "other.yaml"},
+		{"environment variable", "", "env.yaml", "project", "env.yaml"},
+		{"project file", "", "", "project", "project/apis.yaml"},
+		{"project file in a parent", "", "", "project/sub/deeper", "project/apis.yaml"},
+		{".apicli.yaml first", "", "", "dotted", "dotted/.apicli.yaml"},
+		{"user config paths", "", "", "empty", "home/.api/shared.yaml"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(ConfigEnvVar, tt.env)
+			chdir(t, filepath.Join(dir, tt.cwd))
+			got, err := DiscoverConfig(tt.explicit, userConfig, apiDir)
+			if err != nil {
+				t.Fatalf("DiscoverConfig: %v", err)
+			}
+			if got = strings.TrimPrefix(got, dir+string(filepath.Separator)); got != filepath.FromSlash(tt.want) {
+				t.Errorf("DiscoverConfig = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiscoverConfigNotFound(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv(ConfigEnvVar, "")
+	chdir(t, dir)
+
+	_, err := DiscoverConfig("", &UserConfig{APIConfigPath: "apis.yaml"}, filepath.Join(dir, ".api"))
+	if err == nil {
+		t.Fatal("DiscoverConfig: want an error")
+	}
+	for _, want := range []string{dir, filepath.Join(dir, ".api", "apis.yaml"), "--config", ConfigEnvVar} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %s", err, want)
+		}
+	}
+}
+
+func TestConfigPaths(t *testing.T) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		t.Skip("no home directory")
+	}
+	userConfig := &UserConfig{
+		APIConfigPath:  "apis.yaml",
+		APIConfigPaths: []string{"/etc/apicli/apis.yaml", "~/work/apis.yaml", "team/apis.yaml"},
+	}
+	want := []string{
+		filepath.Join("/base", "apis.yaml"),
+		"/etc/apicli/apis.yaml",
+		filepath.Join(home, "work", "apis.yaml"),
+		filepath.Join("/base", "team", "apis.yaml"),
+	}
+	if got := userConfig.ConfigPaths("/base"); !reflect.DeepEqual(got, want) {
+		t.Errorf("ConfigPaths = %v, want %v", got, want)
+	}
+}
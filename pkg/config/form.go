@@ -0,0 +1,49 @@
+package config
+
+import (
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+)
+
+// UnmarshalYAML decodes a form field, which is either a value or a mapping
+// whose file is a single path or a list of them
+func (f *FormField) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		f.Value = node.Value
+		return nil
+	}
+
+	var raw struct {
+		Value       string    `yaml:"value"`
+		File        yaml.Node `yaml:"file"`
+		ContentType string    `yaml:"content_type"`
+	}
+	if err := node.Decode(&raw); err != nil {
+		return err
+	}
+	f.Value, f.ContentType = raw.Value, raw.ContentType
+	switch raw.File.Kind {
+	case 0:
+	case yaml.ScalarNode:
+		f.Files = []string{raw.File.Value}
+	default:
+		if err := raw.File.Decode(&f.Files); err != nil {
+			return err
+		}
+	}
+
+	if f.Value != "" && len(f.Files) > 0 {
+		return fmt.Errorf("line %d: form field sets both value and file", node.Line)
+	}
+	return nil
+}
+
+// Templates returns every template of the field
+func (f FormField) Templates() []string {
+	sources := append([]string{f.Value}, f.Files...)
+	if f.ContentType != "" {
+		sources = append(sources, f.ContentType)
+	}
+	return sources
+}
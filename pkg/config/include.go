@@ -0,0 +1,143 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// includeLoader loads a configuration file and the files it includes,
+// merging them into a single Config
+type includeLoader struct {
+	config     *Config
+	loaded     map[string]bool
+	envSources map[string]string
+	documents  []document
+}
+
+// document is the parsed node tree of a single configuration file,
+// kept so that problems can be reported with file positions
+type document struct {
+	path  string
+	root  *yaml.Node
+	lines []string
+}
+
+func newIncludeLoader(config *Config) *includeLoader {
+	return &includeLoader{
+		config:     config,
+		loaded:     make(map[string]bool),
+		envSources: make(map[string]string),
+	}
+}
+
+// load reads the file at path and merges it into the configuration.
+// Files that were already loaded through another include are skipped.
+func (l *includeLoader) load(path string, root bool) error {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return fmt.Errorf("resolving config path %s: %w", path, err)
+	}
+	if l.loaded[absPath] {
+		return nil
+	}
+	l.loaded[absPath] = true
+
+	data, err := os.ReadFile(absPath)
+	if err != nil {
+		return fmt.Errorf("reading config file: %w", err)
+	}
+
+	var node yaml.Node
+	if err := yaml.Unmarshal(data, &node); err != nil {
+		return fmt.Errorf("parsing config file %s: %w", path, err)
+	}
+
+	var file Config
+	if err := node.Decode(&file); err != nil {
+		return fmt.Errorf("parsing config file %s: %w", path, err)
+	}
+
+	l.documents = append(l.documents, document{
+		path:  absPath,
+		root:  &node,
+		lines: strings.Split(string(data), "\n"),
+	})
+	l.config.Files = append(l.config.Files, absPath)
+	if root {
+		l.config.Include = file.Include
+	}
+
+	if err := l.merge(absPath, &file); err != nil {
+		return err
+	}
+
+	// Includes resolve relative to the including file, not the working directory
+	for _, pattern := range file.Include {
+		paths, err := resolveInclude(filepath.Dir(absPath), pattern)
+		if err != nil {
+			return fmt.Errorf("resolving include '%s' in %s: %w", pattern, absPath, err)
+		}
+		for _, includePath := range paths {
+			if err := l.load(includePath, false); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// merge adds the modules and environments of a single file to the configuration
+func (l *includeLoader) merge(path string, file *Config) error {
+	l.config.SensitiveHeaders = append(l.config.SensitiveHeaders, file.SensitiveHeaders...)
+
+	names := make([]string, 0, len(file.Modules))
+	for name := range file.Modules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if source, ok := l.config.Sources[name]; ok {
+			return fmt.Errorf("module '%s' is defined in both %s and %s", name, source, path)
+		}
+		l.config.Modules[name] = file.Modules[name]
+		l.config.Sources[name] = path
+	}
+
+	for name, env := range file.Environments {
+		if source, ok := l.envSources[name]; ok {
+			return fmt.Errorf("environment '%s' is defined in both %s and %s", name, source, path)
+		}
+		l.config.Environments[name] = env
+		l.envSources[name] = path
+	}
+
+	return nil
+}
+
+// resolveInclude expands an include entry relative to baseDir. Glob patterns
+// may match nothing; plain paths must exist.
+func resolveInclude(baseDir, pattern string) ([]string, error) {
+	if !filepath.IsAbs(pattern) {
+		pattern = filepath.Join(baseDir, pattern)
+	}
+
+	if !strings.ContainsAny(pattern, "*?[") {
+		if _, err := os.Stat(pattern); err != nil {
+			return nil, err
+		}
+		return []string{pattern}, nil
+	}
+
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, err
+	}
+	return matches, nil
+}
@@ -0,0 +1,159 @@
+package config
+
+import (
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// module is a minimal module definition for include tests
+func module(name string) string {
+	return `
+modules:
+  ` + name + `:
+    apis:
+      get:
+        request:
+          method: GET
+          url: https://api.test/` + name + `
+`
+}
+
+func TestLoadConfigIncludes(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"apis.yaml": `
+include:
+  - modules/*.yaml
+  - shared/envs.yaml
+` + module("root")[1:],
+		"modules/users.yaml": module("users"),
+		"modules/orders.yaml": `
+include:
+  - ../shared/envs.yaml
+  - nested/*.yaml
+` + module("orders")[1:],
+		"modules/nested/items.yaml": module("items"),
+		"modules/readme.txt":        "not included",
+		"shared/envs.yaml": `
+environments:
+  dev:
+    variables:
+      host: https://dev.test
+`,
+	})
+
+	config, err := LoadConfig(filepath.Join(dir, "apis.yaml"))
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+
+	var modules []string
+	for name := range config.Modules {
+		modules = append(modules, name)
+	}
+	sort.Strings(modules)
+	if want := []string{"items", "orders", "root", "users"}; !reflect.DeepEqual(modules, want) {
+		t.Errorf("modules = %v, want %v", modules, want)
+	}
+
+	// Includes resolve relative to the including file, and a file included
+	// twice is loaded once
+	sources := map[string]string{}
+	for name, source := range config.Sources {
+		sources[name] = strings.TrimPrefix(source, dir+string(filepath.Separator))
+	}
+	want := map[string]string{
+		"root":   "apis.yaml",
+		"users":  filepath.Join("modules", "users.yaml"),
+		"orders": filepath.Join("modules", "orders.yaml"),
+		"items":  filepath.Join("modules", "nested", "items.yaml"),
+	}
+	if !reflect.DeepEqual(sources, want) {
+		t.Errorf("sources = %v, want %v", sources, want)
+	}
+	if len(config.Files) != 5 {
+		t.Errorf("files = %v, want 5", config.Files)
+	}
+	if config.Environments["dev"].Variables["host"] != "https://dev.test" {
+		t.Errorf("environments = %v", config.Environments)
+	}
+	if config.APITemplates([]string{"items"}, "get") == nil {
+		t.Error("templates of included APIs were not compiled")
+	}
+}
+
+func TestLoadConfigIncludeErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		want  string
+	}{
+		{
+			name: "missing file",
+			files: map[string]string{
+				"apis.yaml": "include: [missing.yaml]\nmodules: {}\n",
+			},
+			want: "resolving include 'missing.yaml' in",
+		},
+		{
+			name: "glob matching nothing",
+			files: map[string]string{
+				"apis.yaml": "include: [modules/*.yaml]\n" + module("root")[1:],
+			},
+		},
+		{
+			name: "module defined twice",
+			files: map[string]string{
+				"apis.yaml":  "include: [users.yaml]\n" + module("users")[1:],
+				"users.yaml": module("users"),
+			},
+			want: "module 'users' is defined in both",
+		},
+		{
+			name: "environment defined twice",
+			files: map[string]string{
+				"apis.yaml": "include: [envs.yaml]\nenvironments:\n  dev: {}\nmodules: {}\n",
+				"envs.yaml": "environments:\n  dev: {}\n",
+			},
+			want: "environment 'dev' is defined in both",
+		},
+		{
+			name: "include cycle",
+			files: map[string]string{
+				"apis.yaml":  "include: [users.yaml]\n" + module("root")[1:],
+				"users.yaml": "include: [apis.yaml]\n" + module("users")[1:],
+			},
+		},
+		{
+			name: "syntax error in an included file",
+			files: map[string]string{
+				"apis.yaml":  "include: [users.yaml]\nmodules: {}\n",
+				"users.yaml": "modules: [\n",
+			},
+			want: "parsing config file",
+		},
+		{
+			name: "template error in an included file",
+			files: map[string]string{
+				"apis.yaml":  "include: [users.yaml]\nmodules: {}\n",
+				"users.yaml": module("users") + "          headers: {X: \"${nope()}\"}\n",
+			},
+			want: "users.yaml:9:25: in '${nope()}': unknown function 'nope'",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := writeFiles(t, tt.files)
+			_, err := LoadConfig(filepath.Join(dir, "apis.yaml"))
+			switch {
+			case tt.want == "" && err != nil:
+				t.Errorf("LoadConfig: %v", err)
+			case tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)):
+				t.Errorf("LoadConfig error = %v, want it to contain %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 
 	"gopkg.in/yaml.v3"
 )
@@ -22,9 +24,9 @@ func LoadOrCreateUserConfig(configPath string) (*UserConfig, error) {
 
 	// If file doesn't exist, create default config
 	if os.IsNotExist(err) {
-		config := UserConfig{
-			APIConfigPath: "apis.yaml", // Default in current directory
-		}
+		// The API configuration is discovered from the working directory,
+		// so the default config does not pin a path
+		config := UserConfig{}
 
 		data, err := yaml.Marshal(&config)
 		if err != nil {
@@ -41,19 +43,24 @@ func LoadOrCreateUserConfig(configPath string) (*UserConfig, error) {
 	return nil, fmt.Errorf("reading user config: %w", err)
 }
 
-// LoadConfig loads API configuration from the given file path
-func LoadConfig(filepath string) (*Config, error) {
-	data, err := os.ReadFile(filepath)
-	if err != nil {
-		return nil, fmt.Errorf("reading config file: %w", err)
+// LoadConfig loads API configuration from the given file path, merging
+// the modules and environments of every file it includes
+func LoadConfig(path string) (*Config, error) {
+	config := &Config{
+		Modules:      make(map[string]Module),
+		Environments: make(map[string]Environment),
+		Sources:      make(map[string]string),
 	}
 
-	var config Config
-	if err := yaml.Unmarshal(data, &config); err != nil {
-		return nil, fmt.Errorf("parsing config file: %w", err)
+	loader := newIncludeLoader(config)
+	if err := loader.load(path, true); err != nil {
+		return nil, err
+	}
+	if err := loader.compileTemplates(); err != nil {
+		return nil, err
 	}
 
-	return &config, nil
+	return config, nil
 }
 
 // InitUserConfigDir ensures the user configuration directory exists
@@ -113,25 +120,42 @@ func CollectModuleInfo(modules map[string]Module, path []string, apiName string)
 	return nil, nil, nil, fmt.Errorf("API '%s' not found in module '%s'", apiName, currentName)
 }
 
-// MergeRequestConfigs merges all request configurations in the module chain
-func MergeRequestConfigs(moduleReqs []RequestConfig, apiReq *RequestSpec) *RequestSpec {
-	mergedReq := *apiReq
-	mergedHeaders := make(map[string]string)
-
-	// Apply module-level headers in order
-	for _, req := range moduleReqs {
-		for k, v := range req.Headers {
-			mergedHeaders[k] = v
+// CredentialHelper returns the credential helper of the innermost module
+// along path that declares one, or ""
+func (c *Config) CredentialHelper(path []string) string {
+	helper := ""
+	modules := c.Modules
+	for _, name := range path {
+		module, ok := modules[name]
+		if !ok {
+			break
+		}
+		if module.CredentialHelper != "" {
+			helper = module.CredentialHelper
 		}
+		modules = module.Modules
+	}
+	return helper
+}
+
+// GetEnvironment returns the named environment, or nil if name is empty
+func (c *Config) GetEnvironment(name string) (*Environment, error) {
+	if name == "" {
+		return nil, nil
 	}
 
-	// Apply API-specific headers (highest priority)
-	if apiReq.Headers != nil {
-		for k, v := range apiReq.Headers {
-			mergedHeaders[k] = v
+	env, ok := c.Environments[name]
+	if !ok {
+		names := make([]string, 0, len(c.Environments))
+		for envName := range c.Environments {
+			names = append(names, envName)
+		}
+		sort.Strings(names)
+		if len(names) == 0 {
+			return nil, fmt.Errorf("environment '%s' not found: no environments defined", name)
 		}
+		return nil, fmt.Errorf("environment '%s' not found (available: %s)", name, strings.Join(names, ", "))
 	}
 
-	mergedReq.Headers = mergedHeaders
-	return &mergedReq
+	return &env, nil
 }
@@ -0,0 +1,179 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/zqtools/apicli/pkg/redact"
+	"github.com/zqtools/apicli/pkg/template"
+)
+
+// patterns caches compiled parameter patterns, which are checked for every
+// value given
+var patterns sync.Map
+
+// compilePattern compiles a parameter pattern once per process
+func compilePattern(pattern string) (*regexp.Regexp, error) {
+	if re, ok := patterns.Load(pattern); ok {
+		return re.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern '%s': %w", pattern, err)
+	}
+	patterns.Store(pattern, re)
+	return re, nil
+}
+
+// Parse converts a value to the parameter's type
+func (p ParamDef) Parse(value string) (interface{}, error) {
+	return template.ParseValue(value, p.Type, p.Format)
+}
+
+// CheckValue verifies that a value satisfies the parameter's constraints.
+// Type checking is left to Parse.
+func (p ParamDef) CheckValue(value string) error {
+	if len(p.Enum) > 0 {
+		found := false
+		for _, allowed := range p.Enum {
+			if value == allowed {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("value must be one of: %s", strings.Join(p.Enum, ", "))
+		}
+	}
+
+	if p.Pattern != "" {
+		re, err := compilePattern(p.Pattern)
+		if err != nil {
+			return err
+		}
+		if !re.MatchString(value) {
+			return fmt.Errorf("value must match pattern '%s'", p.Pattern)
+		}
+	}
+
+	if p.Min != nil || p.Max != nil {
+		number, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("value must be a number")
+		}
+		if p.Min != nil && number < *p.Min {
+			return fmt.Errorf("value must be at least %s", formatNumber(*p.Min))
+		}
+		if p.Max != nil && number > *p.Max {
+			return fmt.Errorf("value must be at most %s", formatNumber(*p.Max))
+		}
+	}
+
+	length := utf8.RuneCountInString(value)
+	if p.MinLength != nil && length < *p.MinLength {
+		return fmt.Errorf("value must be at least %d characters long", *p.MinLength)
+	}
+	if p.MaxLength != nil && length > *p.MaxLength {
+		return fmt.Errorf("value must be at most %d characters long", *p.MaxLength)
+	}
+
+	return nil
+}
+
+// CheckDefinition verifies that the parameter definition is self-consistent:
+// the pattern compiles, min and max bound a numeric type, ranges are ordered
+// and the default and enum values are themselves valid
+func (p ParamDef) CheckDefinition() error {
+	if p.Pattern != "" {
+		if _, err := compilePattern(p.Pattern); err != nil {
+			return err
+		}
+	}
+	if err := template.CheckFormat(p.Type, p.Format); err != nil {
+		return err
+	}
+	if (p.Min != nil || p.Max != nil) && p.Type != "integer" && p.Type != "number" {
+		return fmt.Errorf("min and max only apply to integer and number parameters")
+	}
+	if p.Min != nil && p.Max != nil && *p.Min > *p.Max {
+		return fmt.Errorf("min %s is greater than max %s", formatNumber(*p.Min), formatNumber(*p.Max))
+	}
+	if p.MinLength != nil && p.MaxLength != nil && *p.MinLength > *p.MaxLength {
+		return fmt.Errorf("min_length %d is greater than max_length %d", *p.MinLength, *p.MaxLength)
+	}
+
+	// File values are only checked when used: the file need not exist yet
+	if p.Type == "file" {
+		return nil
+	}
+
+	for _, value := range p.Enum {
+		if _, err := p.Parse(value); err != nil {
+			return fmt.Errorf("enum value '%s': %w", value, err)
+		}
+	}
+
+	if p.Default != nil && *p.Default != "" {
+		if _, err := p.Parse(*p.Default); err != nil {
+			return fmt.Errorf("default '%s': %w", *p.Default, err)
+		}
+		if err := p.CheckValue(*p.Default); err != nil {
+			return fmt.Errorf("default '%s': %w", *p.Default, err)
+		}
+	}
+
+	return nil
+}
+
+// Constraints returns a short human-readable summary of the parameter's
+// default, allowed values and limits, for help output
+func (p ParamDef) Constraints() string {
+	var parts []string
+	if p.Secret {
+		parts = append(parts, "secret")
+	}
+	if p.Env != "" {
+		parts = append(parts, "env: $"+p.Env)
+	}
+	if p.Default != nil {
+		if p.Secret {
+			parts = append(parts, "default: "+redact.Mask)
+		} else {
+			parts = append(parts, fmt.Sprintf("default: %q", *p.Default))
+		}
+	}
+	if len(p.Enum) > 0 {
+		parts = append(parts, "one of: "+strings.Join(p.Enum, "|"))
+	}
+	if p.Pattern != "" {
+		parts = append(parts, "pattern: "+p.Pattern)
+	}
+	switch {
+	case p.Min != nil && p.Max != nil:
+		parts = append(parts, fmt.Sprintf("range: %s..%s", formatNumber(*p.Min), formatNumber(*p.Max)))
+	case p.Min != nil:
+		parts = append(parts, "min: "+formatNumber(*p.Min))
+	case p.Max != nil:
+		parts = append(parts, "max: "+formatNumber(*p.Max))
+	}
+	switch {
+	case p.MinLength != nil && p.MaxLength != nil:
+		parts = append(parts, fmt.Sprintf("length: %d..%d", *p.MinLength, *p.MaxLength))
+	case p.MinLength != nil:
+		parts = append(parts, fmt.Sprintf("min length: %d", *p.MinLength))
+	case p.MaxLength != nil:
+		parts = append(parts, fmt.Sprintf("max length: %d", *p.MaxLength))
+	}
+	if p.Example != "" {
+		parts = append(parts, fmt.Sprintf("e.g. %q", p.Example))
+	}
+	return strings.Join(parts, ", ")
+}
+
+func formatNumber(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
@@ -0,0 +1,205 @@
+package config
+
+import (
+	"net/http"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// bodyMethods lists the methods that inherit a module-level default body
+var bodyMethods = map[string]bool{
+	"POST":  true,
+	"PUT":   true,
+	"PATCH": true,
+}
+
+// UnmarshalYAML decodes a module request block, recording headers set to null
+func (r *RequestConfig) UnmarshalYAML(node *yaml.Node) error {
+	type plain RequestConfig
+	if err := node.Decode((*plain)(r)); err != nil {
+		return err
+	}
+	r.RemoveHeaders = nullHeaders(node, r.Headers)
+	return nil
+}
+
+// UnmarshalYAML decodes an API request block, recording headers set to null
+func (r *RequestSpec) UnmarshalYAML(node *yaml.Node) error {
+	type plain RequestSpec
+	if err := node.Decode((*plain)(r)); err != nil {
+		return err
+	}
+	r.RemoveHeaders = nullHeaders(node, r.Headers)
+	return nil
+}
+
+// nullHeaders returns the names of headers whose value is null in a request
+// node and drops them from the decoded headers
+func nullHeaders(node *yaml.Node, headers map[string]string) []string {
+	headersNode := mappingValue(node, "headers")
+	if headersNode == nil || headersNode.Kind != yaml.MappingNode {
+		return nil
+	}
+
+	var removed []string
+	for i := 0; i+1 < len(headersNode.Content); i += 2 {
+		if headersNode.Content[i+1].Tag == "!!null" {
+			name := headersNode.Content[i].Value
+			removed = append(removed, name)
+			delete(headers, name)
+		}
+	}
+	return removed
+}
+
+// HasJSONBody reports whether the request declares a structured json body
+func (r *RequestSpec) HasJSONBody() bool {
+	return r.JSON.Kind != 0
+}
+
+// IsJSONBody reports whether the body template is a JSON document, either
+// because it is marked "format: json" or because Content-Type says so
+func (r *RequestSpec) IsJSONBody() bool {
+	if r.HasJSONBody() {
+		return true
+	}
+	switch r.Format {
+	case "json":
+		return true
+	case "text":
+		return false
+	}
+	for name, value := range r.Headers {
+		if http.CanonicalHeaderKey(name) == "Content-Type" {
+			return strings.Contains(value, "json")
+		}
+	}
+	return false
+}
+
+// IsRelativeURL reports whether an API URL must be joined with an inherited
+// base URL. URLs starting with a variable get their base from that variable.
+func IsRelativeURL(url string) bool {
+	return !strings.Contains(url, "://") && !strings.HasPrefix(url, "${")
+}
+
+// joinURL joins a base URL and a relative path with exactly one slash
+func joinURL(base, path string) string {
+	if path == "" {
+		return base
+	}
+	return strings.TrimRight(base, "/") + "/" + strings.TrimLeft(path, "/")
+}
+
+// MergeRequestConfigs merges all request configurations in the module chain.
+// Later (deeper) settings override earlier ones and the API's own settings
+// have the highest priority.
+func MergeRequestConfigs(moduleReqs []RequestConfig, apiReq *RequestSpec) *RequestSpec {
+	mergedReq := *apiReq
+	mergedHeaders := make(map[string]string)
+	var mergedParams []QueryParam
+	var baseURL, body string
+	var auth *AuthConfig
+
+	// Apply module-level settings in order
+	for _, req := range moduleReqs {
+		applyHeaders(mergedHeaders, req.Headers, req.RemoveHeaders)
+		auth = mergeAuth(auth, req.Auth, req.RemoveHeaders)
+		mergedParams = mergeQueryParams(mergedParams, req.Params)
+		if req.BaseURL != "" {
+			baseURL = req.BaseURL
+		}
+		if req.Timeout != "" && apiReq.Timeout == "" {
+			mergedReq.Timeout = req.Timeout
+		}
+		if req.Retries != nil && apiReq.Retries == nil {
+			mergedReq.Retries = req.Retries
+		}
+		if len(req.RetryOn) > 0 && len(apiReq.RetryOn) == 0 {
+			mergedReq.RetryOn = req.RetryOn
+		}
+		if req.Backoff != "" && apiReq.Backoff == "" {
+			mergedReq.Backoff = req.Backoff
+		}
+		if req.MaxBackoff != "" && apiReq.MaxBackoff == "" {
+			mergedReq.MaxBackoff = req.MaxBackoff
+		}
+		if req.Body != "" {
+			body = req.Body
+		}
+	}
+
+	// Apply API-specific headers and params (highest priority)
+	applyHeaders(mergedHeaders, apiReq.Headers, apiReq.RemoveHeaders)
+	mergedReq.Headers = mergedHeaders
+	mergedReq.Auth = mergeAuth(auth, apiReq.Auth, apiReq.RemoveHeaders)
+	mergedReq.Params = mergeQueryParams(mergedParams, apiReq.Params)
+	mergedReq.RemoveHeaders = nil
+
+	if baseURL != "" && IsRelativeURL(apiReq.URL) {
+		mergedReq.URL = joinURL(baseURL, apiReq.URL)
+	}
+
+	// A default body only applies to APIs that send a body but define none
+	if body != "" && bodyMethods[strings.ToUpper(apiReq.Method)] &&
+		apiReq.Body == "" && !apiReq.HasJSONBody() && apiReq.BodyFile == "" && len(apiReq.Form) == 0 {
+		mergedReq.Body = body
+	}
+
+	return &mergedReq
+}
+
+// mergeAuth returns the auth block in effect after a request block: its own,
+// or the inherited one unless the block removes the Authorization header
+func mergeAuth(inherited, own *AuthConfig, removeHeaders []string) *AuthConfig {
+	if own != nil {
+		return own
+	}
+	for _, name := range removeHeaders {
+		if http.CanonicalHeaderKey(name) == "Authorization" {
+			return nil
+		}
+	}
+	return inherited
+}
+
+// applyHeaders sets headers on merged and removes the named ones, matching
+// header names case-insensitively
+func applyHeaders(merged, headers map[string]string, remove []string) {
+	for k, v := range headers {
+		deleteHeader(merged, k)
+		merged[k] = v
+	}
+	for _, name := range remove {
+		deleteHeader(merged, name)
+	}
+}
+
+func deleteHeader(headers map[string]string, name string) {
+	canonical := http.CanonicalHeaderKey(name)
+	for k := range headers {
+		if http.CanonicalHeaderKey(k) == canonical {
+			delete(headers, k)
+		}
+	}
+}
+
+// mergeQueryParams appends params to base, replacing params with the same name
+func mergeQueryParams(base, params []QueryParam) []QueryParam {
+	merged := append([]QueryParam{}, base...)
+	for _, param := range params {
+		replaced := false
+		for i := range merged {
+			if merged[i].Name == param.Name {
+				merged[i] = param
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			merged = append(merged, param)
+		}
+	}
+	return merged
+}
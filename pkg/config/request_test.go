@@ -0,0 +1,217 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+// mergeChain decodes a module chain and an API, written as YAML, and
+// merges their request blocks the way a call does
+func mergeChain(t *testing.T, source string) *RequestSpec {
+	t.Helper()
+	var config Config
+	if err := yaml.Unmarshal([]byte(source), &config); err != nil {
+		t.Fatalf("parsing config: %v", err)
+	}
+	_, reqs, api, err := CollectModuleInfo(config.Modules, []string{"outer", "inner"}, "call")
+	if err != nil {
+		t.Fatalf("CollectModuleInfo: %v", err)
+	}
+	return MergeRequestConfigs(reqs, &api.Request)
+}
+
+func TestMergeRequestConfigs(t *testing.T) {
+	merged := mergeChain(t, `
+modules:
+  outer:
+    request:
+      base_url: https://outer.test/v1/
+      timeout: 10s
+      retries: 2
+      headers:
+        Accept: application/json
+        X-Outer: outer
+        X-Trace: "1"
+      params:
+        - {name: page, value: "1"}
+        - {name: lang, value: en}
+      auth:
+        type: bearer
+        token: outer
+    modules:
+      inner:
+        request:
+          base_url: https://inner.test/v2
+          backoff: 1s
+          headers:
+            x-outer: inner
+            X-Trace: null
+          params:
+            - {name: page, value: "2"}
+        apis:
+          call:
+            request:
+              method: GET
+              url: /items/
+              timeout: 5s
+              headers:
+                Accept: text/plain
+              params:
+                - {name: limit, value: "10"}
+`)
+
+	retries := 2
+	want := &RequestSpec{
+		Method:  "GET",
+		URL:     "https://inner.test/v2/items/",
+		Timeout: "5s",
+		Backoff: "1s",
+		Headers: map[string]string{"Accept": "text/plain", "x-outer": "inner"},
+		Params: []QueryParam{
+			{Name: "page", Value: "2"},
+			{Name: "lang", Value: "en"},
+			{Name: "limit", Value: "10"},
+		},
+		Auth:    &AuthConfig{Type: "bearer", Token: "outer"},
+		Retries: &retries,
+	}
+	if !reflect.DeepEqual(merged, want) {
+		t.Errorf("merged request:\n%+v\nwant:\n%+v", merged, want)
+	}
+}
+
+func TestMergeRequestConfigsURL(t *testing.T) {
+	tests := []struct{ baseURL, url, want string }{
+		{"https://api.test", "/users", "https://api.test/users"},
+		{"https://api.test/", "users", "https://api.test/users"},
+		{"https://api.test/v1", "", "https://api.test/v1"},
+		{"https://api.test", "https://other.test/users", "https://other.test/users"},
+		{"https://api.test", "${host}/users", "${host}/users"},
+		{"", "/users", "/users"},
+	}
+	for _, tt := range tests {
+		merged := MergeRequestConfigs([]RequestConfig{{BaseURL: tt.baseURL}}, &RequestSpec{URL: tt.url})
+		if merged.URL != tt.want {
+			t.Errorf("base_url %q, url %q: URL = %q, want %q", tt.baseURL, tt.url, merged.URL, tt.want)
+		}
+	}
+}
+
+func TestMergeRequestConfigsBody(t *testing.T) {
+	module := []RequestConfig{{Body: `{"default": true}`}}
+	tests := []struct {
+		name string
+		api  RequestSpec
+		want string
+	}{
+		{"post without a body", RequestSpec{Method: "post"}, `{"default": true}`},
+		{"patch without a body", RequestSpec{Method: "PATCH"}, `{"default": true}`},
+		{"get", RequestSpec{Method: "GET"}, ""},
+		{"delete", RequestSpec{Method: "DELETE"}, ""},
+		{"own body", RequestSpec{Method: "POST", Body: "own"}, "own"},
+		{"body file", RequestSpec{Method: "POST", BodyFile: "body.json"}, ""},
+		{"form", RequestSpec{Method: "POST", Form: map[string]FormField{"a": {Value: "b"}}}, ""},
+		{"json", RequestSpec{Method: "POST", JSON: yaml.Node{Kind: yaml.MappingNode}}, ""},
+	}
+	for _, tt := range tests {
+		if got := MergeRequestConfigs(module, &tt.api).Body; got != tt.want {
+			t.Errorf("%s: Body = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMergeRequestConfigsRetrySettings(t *testing.T) {
+	two, five := 2, 5
+	modules := []RequestConfig{
+		{Retries: &two, RetryOn: []string{"503"}, MaxBackoff: "1m"},
+		{Backoff: "2s"},
+	}
+
+	merged := MergeRequestConfigs(modules, &RequestSpec{})
+	if *merged.Retries != 2 || !reflect.DeepEqual(merged.RetryOn, []string{"503"}) ||
+		merged.Backoff != "2s" || merged.MaxBackoff != "1m" {
+		t.Errorf("inherited retry settings = %d %v %s %s", *merged.Retries, merged.RetryOn, merged.Backoff, merged.MaxBackoff)
+	}
+
+	merged = MergeRequestConfigs(modules, &RequestSpec{Retries: &five, RetryOn: []string{"5xx"}, Backoff: "1s"})
+	if *merged.Retries != 5 || !reflect.DeepEqual(merged.RetryOn, []string{"5xx"}) || merged.Backoff != "1s" {
+		t.Errorf("own retry settings = %d %v %s", *merged.Retries, merged.RetryOn, merged.Backoff)
+	}
+}
+
+func TestMergeAuth(t *testing.T) {
+	outer := &AuthConfig{Type: "bearer", Token: "outer"}
+	inner := &AuthConfig{Type: "basic", Username: "ann"}
+
+	tests := []struct {
+		name      string
+		inherited *AuthConfig
+		own       *AuthConfig
+		remove    []string
+		want      *AuthConfig
+	}{
+		{"inherited", outer, nil, nil, outer},
+		{"own wins", outer, inner, nil, inner},
+		{"own wins over a removed header", outer, inner, []string{"Authorization"}, inner},
+		{"removed by a null Authorization header", outer, nil, []string{"authorization"}, nil},
+		{"other headers removed", outer, nil, []string{"X-Trace"}, outer},
+		{"nothing to inherit", nil, nil, nil, nil},
+	}
+	for _, tt := range tests {
+		if got := mergeAuth(tt.inherited, tt.own, tt.remove); got != tt.want {
+			t.Errorf("%s: mergeAuth = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMergeAuthAlongChain(t *testing.T) {
+	merged := mergeChain(t, `
+modules:
+  outer:
+    request:
+      auth:
+        type: bearer
+        token: outer
+    modules:
+      inner:
+        request:
+          headers:
+            Authorization: null
+        apis:
+          call:
+            request:
+              method: GET
+              url: https://api.test/
+`)
+	if merged.Auth != nil {
+		t.Errorf("Auth = %+v, want it removed by the null Authorization header", merged.Auth)
+	}
+	if len(merged.Headers) != 0 {
+		t.Errorf("Headers = %v, want none", merged.Headers)
+	}
+}
+
+func TestNullHeaders(t *testing.T) {
+	var spec RequestSpec
+	err := yaml.Unmarshal([]byte(`
+method: GET
+headers:
+  Accept: application/json
+  X-Trace: null
+  X-Debug: ~
+  X-Empty: ""
+`), &spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{"X-Trace", "X-Debug"}; !reflect.DeepEqual(spec.RemoveHeaders, want) {
+		t.Errorf("RemoveHeaders = %v, want %v", spec.RemoveHeaders, want)
+	}
+	// An empty string is a value, not a removal
+	if want := map[string]string{"Accept": "application/json", "X-Empty": ""}; !reflect.DeepEqual(spec.Headers, want) {
+		t.Errorf("Headers = %v, want %v", spec.Headers, want)
+	}
+}
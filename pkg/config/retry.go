@@ -0,0 +1,145 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultTimeout bounds requests that set no timeout
+	DefaultTimeout = 30 * time.Second
+
+	defaultBackoff    = 500 * time.Millisecond
+	defaultMaxBackoff = 30 * time.Second
+)
+
+// defaultRetryOn is used when retries are enabled without retry_on
+var defaultRetryOn = []string{"connection", "429", "502", "503", "504"}
+
+// defaultRetryMethods are the methods defaultRetryOn applies to: resending
+// them cannot repeat a side effect the failed attempt may already have had.
+// Other methods are only retried under an explicit retry_on.
+var defaultRetryMethods = map[string]bool{
+	"GET":     true,
+	"HEAD":    true,
+	"OPTIONS": true,
+	"TRACE":   true,
+	"PUT":     true,
+}
+
+// RetryPolicy is the resolved timeout and retry behaviour of a request
+type RetryPolicy struct {
+	Timeout    time.Duration
+	Retries    int
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+
+	statuses   map[int]bool // exact status codes
+	classes    map[int]bool // status classes, 5 for 5xx
+	connection bool         // connection errors and timeouts
+}
+
+// RetryPolicy resolves the request's timeout, retries, retry_on, backoff
+// and max_backoff settings
+func (r *RequestSpec) RetryPolicy() (*RetryPolicy, error) {
+	policy := &RetryPolicy{
+		Timeout:    DefaultTimeout,
+		Backoff:    defaultBackoff,
+		MaxBackoff: defaultMaxBackoff,
+		statuses:   make(map[int]bool),
+		classes:    make(map[int]bool),
+	}
+
+	var err error
+	if r.Timeout != "" {
+		if policy.Timeout, err = parseDuration("timeout", r.Timeout); err != nil {
+			return nil, err
+		}
+	}
+	if r.Backoff != "" {
+		if policy.Backoff, err = parseDuration("backoff", r.Backoff); err != nil {
+			return nil, err
+		}
+	}
+	if r.MaxBackoff != "" {
+		if policy.MaxBackoff, err = parseDuration("max_backoff", r.MaxBackoff); err != nil {
+			return nil, err
+		}
+	}
+	if r.Retries != nil {
+		if *r.Retries < 0 {
+			return nil, fmt.Errorf("retries must not be negative")
+		}
+		policy.Retries = *r.Retries
+	}
+
+	retryOn := r.RetryOn
+	if len(retryOn) == 0 {
+		if !r.retriedByDefault() {
+			policy.Retries = 0
+			return policy, nil
+		}
+		retryOn = defaultRetryOn
+	}
+	for _, condition := range retryOn {
+		if err := policy.addCondition(condition); err != nil {
+			return nil, err
+		}
+	}
+	return policy, nil
+}
+
+// RetriesIgnored reports whether the request asks for retries that are not
+// made because its method is only retried under an explicit retry_on
+func (r *RequestSpec) RetriesIgnored() bool {
+	return r.Retries != nil && *r.Retries > 0 && len(r.RetryOn) == 0 && !r.retriedByDefault()
+}
+
+// retriedByDefault reports whether defaultRetryOn applies to the request's
+// method. A request without a method is sent as a GET.
+func (r *RequestSpec) retriedByDefault() bool {
+	method := strings.ToUpper(r.Method)
+	return method == "" || defaultRetryMethods[method]
+}
+
+// addCondition adds a retry_on entry: a status code (503), a status class
+// (5xx) or "connection"
+func (p *RetryPolicy) addCondition(condition string) error {
+	condition = strings.ToLower(strings.TrimSpace(condition))
+	switch {
+	case condition == "connection":
+		p.connection = true
+	case len(condition) == 3 && strings.HasSuffix(condition, "xx") && condition[0] >= '1' && condition[0] <= '5':
+		p.classes[int(condition[0]-'0')] = true
+	default:
+		code, err := strconv.Atoi(condition)
+		if err != nil || code < 100 || code > 599 {
+			return fmt.Errorf("invalid retry_on entry '%s' (expected a status code, a class such as 5xx, or connection)", condition)
+		}
+		p.statuses[code] = true
+	}
+	return nil
+}
+
+// RetryStatus reports whether a response with the given status is retried
+func (p *RetryPolicy) RetryStatus(code int) bool {
+	return p.statuses[code] || p.classes[code/100]
+}
+
+// RetryErrors reports whether connection errors and timeouts are retried
+func (p *RetryPolicy) RetryErrors() bool {
+	return p.connection
+}
+
+func parseDuration(setting, value string) (time.Duration, error) {
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s '%s': %w", setting, value, err)
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("invalid %s '%s': must not be negative", setting, value)
+	}
+	return d, nil
+}
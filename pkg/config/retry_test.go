@@ -0,0 +1,98 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	three := 3
+	tests := []struct {
+		name     string
+		spec     RequestSpec
+		retries  int
+		statuses map[int]bool
+		errors   bool
+		ignored  bool
+	}{
+		{
+			name:     "defaults for GET",
+			spec:     RequestSpec{Method: "get", Retries: &three},
+			retries:  3,
+			statuses: map[int]bool{429: true, 500: false, 502: true, 503: true, 504: true},
+			errors:   true,
+		},
+		{
+			name:    "defaults without a method",
+			spec:    RequestSpec{Retries: &three},
+			retries: 3,
+			errors:  true,
+		},
+		{
+			name:    "POST without retry_on",
+			spec:    RequestSpec{Method: "POST", Retries: &three},
+			ignored: true,
+		},
+		{
+			name:     "POST with retry_on",
+			spec:     RequestSpec{Method: "POST", Retries: &three, RetryOn: []string{"5xx", "429"}},
+			retries:  3,
+			statuses: map[int]bool{429: true, 500: true, 599: true, 404: false},
+		},
+		{
+			name: "POST without retries",
+			spec: RequestSpec{Method: "POST"},
+		},
+	}
+	for _, tt := range tests {
+		policy, err := tt.spec.RetryPolicy()
+		if err != nil {
+			t.Errorf("%s: RetryPolicy: %v", tt.name, err)
+			continue
+		}
+		if policy.Retries != tt.retries {
+			t.Errorf("%s: Retries = %d, want %d", tt.name, policy.Retries, tt.retries)
+		}
+		for code, want := range tt.statuses {
+			if policy.RetryStatus(code) != want {
+				t.Errorf("%s: RetryStatus(%d) = %v, want %v", tt.name, code, !want, want)
+			}
+		}
+		if policy.RetryErrors() != tt.errors {
+			t.Errorf("%s: RetryErrors = %v, want %v", tt.name, !tt.errors, tt.errors)
+		}
+		if got := tt.spec.RetriesIgnored(); got != tt.ignored {
+			t.Errorf("%s: RetriesIgnored = %v, want %v", tt.name, got, tt.ignored)
+		}
+	}
+}
+
+func TestRetryPolicyDurations(t *testing.T) {
+	policy, err := (&RequestSpec{}).RetryPolicy()
+	if err != nil {
+		t.Fatalf("RetryPolicy: %v", err)
+	}
+	if policy.Timeout != DefaultTimeout || policy.Backoff != defaultBackoff || policy.MaxBackoff != defaultMaxBackoff {
+		t.Errorf("defaults = %s, %s, %s", policy.Timeout, policy.Backoff, policy.MaxBackoff)
+	}
+
+	policy, err = (&RequestSpec{Timeout: "5s", Backoff: "1s", MaxBackoff: "1m"}).RetryPolicy()
+	if err != nil {
+		t.Fatalf("RetryPolicy: %v", err)
+	}
+	if policy.Timeout != 5*time.Second || policy.Backoff != time.Second || policy.MaxBackoff != time.Minute {
+		t.Errorf("durations = %s, %s, %s", policy.Timeout, policy.Backoff, policy.MaxBackoff)
+	}
+
+	negative := -1
+	for _, spec := range []RequestSpec{
+		{Timeout: "-1s"},
+		{Backoff: "fast"},
+		{Retries: &negative},
+		{RetryOn: []string{"999"}},
+	} {
+		if _, err := spec.RetryPolicy(); err == nil {
+			t.Errorf("RetryPolicy(%+v): want an error", spec)
+		}
+	}
+}
@@ -0,0 +1,244 @@
+package config
+
+import (
+	"strings"
+
+	"github.com/zqtools/apicli/pkg/template"
+	"gopkg.in/yaml.v3"
+)
+
+// apiKey identifies an API in the template index
+func apiKey(modulePath []string, apiName string) string {
+	return strings.Join(modulePath, ".") + " " + apiName
+}
+
+// APITemplates returns the compiled templates used by an API: those of its
+// own request block and of every module request block above it
+func (c *Config) APITemplates(modulePath []string, apiName string) *template.Set {
+	return c.templates[apiKey(modulePath, apiName)]
+}
+
+// compileTemplates parses every template string of the loaded documents
+// once, so syntax errors surface at load time with their file position
+// and rendering never has to parse again
+func (l *includeLoader) compileTemplates() error {
+	l.config.templates = make(map[string]*template.Set)
+	for _, doc := range l.documents {
+		root := doc.root
+		if root.Kind == yaml.DocumentNode && len(root.Content) > 0 {
+			root = root.Content[0]
+		}
+		if err := l.compileModules(doc, mappingValue(root, "modules"), nil, nil, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// compileModules compiles the templates of every module and API below node.
+// inherited holds the templates of enclosing modules, innermost first, and
+// baseURL the nearest base_url node.
+func (l *includeLoader) compileModules(doc document, node *yaml.Node, path []string, inherited []*template.Template, baseURL *yaml.Node) error {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		moduleNode := node.Content[i+1]
+		modulePath := append(append([]string{}, path...), node.Content[i].Value)
+
+		moduleTemplates := inherited
+		moduleBaseURL := baseURL
+		if request := mappingValue(moduleNode, "request"); request != nil {
+			compiled, err := compileScalars(doc, request)
+			if err != nil {
+				return err
+			}
+			moduleTemplates = append(compiled, inherited...)
+			if base := mappingValue(request, "base_url"); base != nil {
+				moduleBaseURL = base
+			}
+		}
+
+		if err := l.compileModules(doc, mappingValue(moduleNode, "modules"), modulePath, moduleTemplates, moduleBaseURL); err != nil {
+			return err
+		}
+
+		apis := mappingValue(moduleNode, "apis")
+		if apis == nil || apis.Kind != yaml.MappingNode {
+			continue
+		}
+		for j := 0; j+1 < len(apis.Content); j += 2 {
+			set := template.NewSet()
+
+			// The API's own templates win lookups of identical source text,
+			// except json body leaves: a lone reference there is optional, so
+			// it must not lend its position to the same text elsewhere
+			request := mappingValue(apis.Content[j+1], "request")
+			var jsonBody []*template.Template
+			for k := 0; request != nil && request.Kind == yaml.MappingNode && k+1 < len(request.Content); k += 2 {
+				compiled, err := compileScalars(doc, request.Content[k+1])
+				if err != nil {
+					return err
+				}
+				if request.Content[k].Value == "json" {
+					jsonBody = compiled
+					continue
+				}
+				for _, t := range compiled {
+					set.Add(t)
+				}
+			}
+
+			if rawURL := mappingValue(request, "url"); rawURL != nil && moduleBaseURL != nil && IsRelativeURL(rawURL.Value) {
+				joined, err := compileJoinedURL(doc, moduleBaseURL, rawURL)
+				if err != nil {
+					return err
+				}
+				set.Add(joined)
+			}
+
+			for _, t := range moduleTemplates {
+				set.Add(t)
+			}
+			for _, t := range jsonBody {
+				set.Add(t)
+			}
+
+			l.config.templates[apiKey(modulePath, apis.Content[j].Value)] = set
+		}
+	}
+
+	return nil
+}
+
+// compileScalars compiles every scalar value below node
+func compileScalars(doc document, node *yaml.Node) ([]*template.Template, error) {
+	var compiled []*template.Template
+	for _, scalar := range scalarNodes(node) {
+		t, err := template.CompileAt(scalar.Value, doc.locator(scalar))
+		if err != nil {
+			return nil, err
+		}
+		compiled = append(compiled, t)
+	}
+	return compiled, nil
+}
+
+// compileJoinedURL compiles the URL that MergeRequestConfigs builds from a
+// base URL and a relative API URL, locating placeholders in either node
+func compileJoinedURL(doc document, baseNode, urlNode *yaml.Node) (*template.Template, error) {
+	base := strings.TrimRight(baseNode.Value, "/")
+	trimmed := len(urlNode.Value) - len(strings.TrimLeft(urlNode.Value, "/"))
+	locateBase, locateURL := doc.locator(baseNode), doc.locator(urlNode)
+
+	return template.CompileAt(joinURL(baseNode.Value, urlNode.Value), func(offset int) template.Position {
+		if offset < len(base) {
+			return locateBase(offset)
+		}
+		return locateURL(offset - len(base) - 1 + trimmed)
+	})
+}
+
+// locator returns a function mapping offsets in a scalar's value to file
+// positions. Lines are derived from the scalar's style; columns are found by
+// looking the text up on the source line, falling back to the scalar's start.
+func (d document) locator(node *yaml.Node) template.Locator {
+	return func(offset int) template.Position {
+		pos := template.Position{File: d.path, Line: node.Line, Column: node.Column}
+		if offset < 0 || offset > len(node.Value) {
+			return pos
+		}
+
+		newlines := strings.Count(node.Value[:offset], "\n")
+		switch node.Style {
+		case yaml.LiteralStyle, yaml.FoldedStyle:
+			// Block scalar content starts on the line after the indicator
+			pos.Line = node.Line + 1 + newlines
+		default:
+			pos.Line = node.Line + newlines
+		}
+		if pos.Line-1 >= len(d.lines) {
+			return pos
+		}
+
+		text := node.Value[offset:]
+		if end := strings.IndexByte(text, '\n'); end >= 0 {
+			text = text[:end]
+		}
+		line := d.lines[pos.Line-1]
+		from := 0
+		if pos.Line == node.Line && node.Column-1 <= len(line) {
+			from = node.Column - 1
+		}
+		if idx := strings.Index(line[from:], text); idx >= 0 {
+			pos.Column = from + idx + 1
+		} else {
+			pos.Column = 1
+		}
+		return pos
+	}
+}
+
+// RequiredReferences returns the references the merged request cannot be
+// rendered without, positioned using the API's compiled templates. Query
+// parameters are skipped since they are dropped when they do not render,
+// as are lone references in a json body, which are omitted when unset.
+func (r *RequestSpec) RequiredReferences(templates *template.Set) ([]template.Reference, error) {
+	sources := []string{r.URL}
+	if r.Download != "" {
+		sources = append(sources, r.Download)
+	}
+	for _, value := range r.Headers {
+		sources = append(sources, value)
+	}
+	if r.Auth != nil && r.Auth.Type != "none" {
+		for _, field := range r.Auth.templateFields() {
+			sources = append(sources, *field)
+		}
+	}
+
+	var jsonTemplates []*template.Template
+	switch {
+	case r.BodyFile != "":
+		sources = append(sources, r.BodyFile)
+	case len(r.Form) > 0:
+		for _, field := range r.Form {
+			sources = append(sources, field.Templates()...)
+		}
+	case r.HasJSONBody():
+		for _, scalar := range scalarNodes(&r.JSON) {
+			t, err := lookupTemplate(templates, scalar.Value)
+			if err != nil {
+				return nil, err
+			}
+			if !t.IsLoneReference() {
+				jsonTemplates = append(jsonTemplates, t)
+			}
+		}
+	default:
+		sources = append(sources, r.Body)
+	}
+
+	var refs []template.Reference
+	for _, source := range sources {
+		t, err := lookupTemplate(templates, source)
+		if err != nil {
+			return nil, err
+		}
+		refs = append(refs, t.Required()...)
+	}
+	for _, t := range jsonTemplates {
+		refs = append(refs, t.Required()...)
+	}
+	return refs, nil
+}
+
+// lookupTemplate returns the compiled template for source, compiling it
+// without position information when the set does not hold it
+func lookupTemplate(templates *template.Set, source string) (*template.Template, error) {
+	if t := templates.Lookup(source); t != nil {
+		return t, nil
+	}
+	return template.Compile(source)
+}
@@ -1,13 +1,60 @@
 package config
 
+import (
+	"github.com/zqtools/apicli/pkg/template"
+	"gopkg.in/yaml.v3"
+)
+
 // Config represents the main API configuration
 type Config struct {
-	Modules map[string]Module `yaml:"modules"`
+	Include      []string               `yaml:"include,omitempty"`
+	// SensitiveHeaders lists headers masked in output and history in
+	// addition to the built-in ones (Authorization, Cookie, ...)
+	SensitiveHeaders []string `yaml:"sensitive_headers,omitempty"`
+	Environments map[string]Environment `yaml:"environments,omitempty"`
+	Modules      map[string]Module      `yaml:"modules"`
+
+	// Files lists every file that contributed to this configuration, in load order
+	Files []string `yaml:"-"`
+	// Sources maps each top-level module name to the file that defined it
+	Sources map[string]string `yaml:"-"`
+
+	// templates holds the compiled templates of each API, keyed by apiKey
+	templates map[string]*template.Set
 }
 
 // UserConfig represents user-specific configuration
 type UserConfig struct {
-	APIConfigPath string `yaml:"api_config_path"`
+	// APIConfigPath and APIConfigPaths are fallbacks used when no project
+	// configuration is found; relative paths resolve against ~/.api
+	APIConfigPath  string   `yaml:"api_config_path,omitempty"`
+	APIConfigPaths []string `yaml:"api_config_paths,omitempty"`
+	DefaultEnv     string   `yaml:"default_env,omitempty"`
+}
+
+// Context is a named, persistent set of parameter values that fill in
+// parameters not given as flags while the context is active. Value keys are
+// parameter names, optionally prefixed with a module path ("user.token")
+// to apply only to APIs below that module.
+type Context struct {
+	Environment string            `yaml:"environment,omitempty"`
+	Values      map[string]string `yaml:"values,omitempty"`
+}
+
+// Contexts holds the user's contexts and the active one. It is stored in
+// ~/.api/contexts, next to the user configuration.
+type Contexts struct {
+	Current  string             `yaml:"current,omitempty"`
+	Contexts map[string]Context `yaml:"contexts,omitempty"`
+
+	path string
+}
+
+// Environment represents a named set of variables (base URLs, default tokens, ...)
+// that are made available to templates when the environment is selected
+type Environment struct {
+	Description string            `yaml:"description,omitempty"`
+	Variables   map[string]string `yaml:"variables,omitempty"`
 }
 
 // Module represents an API module configuration
@@ -17,25 +64,64 @@ type Module struct {
 	Request     *RequestConfig   `yaml:"request,omitempty"`
 	Modules     map[string]Module `yaml:"modules,omitempty"`
 	APIs        map[string]APISpec `yaml:"apis,omitempty"`
+
+	// CredentialHelper is a command run to supply the ${credentials.NAME}
+	// values of the module and its submodules
+	CredentialHelper string `yaml:"credential_helper,omitempty"`
 }
 
 // APISpec represents an API specification
 type APISpec struct {
-	Params  []ParamDef  `yaml:"params"`
-	Request RequestSpec `yaml:"request"`
+	Params  []ParamDef             `yaml:"params"`
+	Request RequestSpec            `yaml:"request"`
+	Capture map[string]CaptureSpec `yaml:"capture,omitempty"`
+}
+
+// CaptureSpec extracts a value from an API's response into a variable that
+// later calls reference as ${vars.NAME}
+type CaptureSpec struct {
+	Path   string `yaml:"path"`
+	TTL    string `yaml:"ttl,omitempty"`
+	Secret bool   `yaml:"secret,omitempty"`
 }
 
 // ParamDef represents a parameter definition
 type ParamDef struct {
-	Name        string `yaml:"name"`
-	Type        string `yaml:"type"`
-	Required    bool   `yaml:"required"`
-	Description string `yaml:"description"`
+	Name        string   `yaml:"name"`
+	Type        string   `yaml:"type"`
+	Format      string   `yaml:"format,omitempty"`
+	Required    bool     `yaml:"required"`
+	Description string   `yaml:"description"`
+	Default     *string  `yaml:"default,omitempty"`
+	Enum        []string `yaml:"enum,omitempty"`
+	Pattern     string   `yaml:"pattern,omitempty"`
+	Min         *float64 `yaml:"min,omitempty"`
+	Max         *float64 `yaml:"max,omitempty"`
+	MinLength   *int     `yaml:"min_length,omitempty"`
+	MaxLength   *int     `yaml:"max_length,omitempty"`
+	Example     string   `yaml:"example,omitempty"`
+	Env         string   `yaml:"env,omitempty"`
+	Secret      bool     `yaml:"secret,omitempty"`
 }
 
-// RequestConfig represents module-level request configuration
+// RequestConfig represents module-level request configuration inherited by
+// every API below the module
 type RequestConfig struct {
+	BaseURL string            `yaml:"base_url,omitempty"`
 	Headers map[string]string `yaml:"headers,omitempty"`
+	Params  []QueryParam      `yaml:"params,omitempty"`
+	Timeout string            `yaml:"timeout,omitempty"`
+	Auth    *AuthConfig       `yaml:"auth,omitempty"`
+	Body    string            `yaml:"body,omitempty"`
+
+	// Retry settings; see RetryPolicy
+	Retries    *int     `yaml:"retries,omitempty"`
+	RetryOn    []string `yaml:"retry_on,omitempty"`
+	Backoff    string   `yaml:"backoff,omitempty"`
+	MaxBackoff string   `yaml:"max_backoff,omitempty"`
+
+	// RemoveHeaders lists inherited headers removed by setting them to null
+	RemoveHeaders []string `yaml:"-"`
 }
 
 // RequestSpec represents API-specific request configuration
@@ -43,10 +129,86 @@ type RequestSpec struct {
 	Method   string            `yaml:"method"`
 	URL      string            `yaml:"url"`
 	Body     string            `yaml:"body,omitempty"`
+	// JSON is a body written as a YAML tree whose leaves may be ${param} references
+	JSON     yaml.Node         `yaml:"json,omitempty"`
 	BodyFile string           `yaml:"body_file,omitempty"`
-	Form     map[string]string `yaml:"form,omitempty"`
+	Form     map[string]FormField `yaml:"form,omitempty"`
 	Params   []QueryParam     `yaml:"params,omitempty"`
 	Headers  map[string]string `yaml:"headers,omitempty"`
+	Timeout  string            `yaml:"timeout,omitempty"`
+	Auth     *AuthConfig       `yaml:"auth,omitempty"`
+	// Format is "json" or "text"; when empty it is inferred from Content-Type
+	Format string `yaml:"format,omitempty"`
+	// Stream prints the response as it arrives even when its Content-Type
+	// is not an event stream
+	Stream bool `yaml:"stream,omitempty"`
+	// Download saves successful responses to this file, or to a file named
+	// by the response when it is a directory
+	Download string `yaml:"download,omitempty"`
+
+	// Retry settings; see RetryPolicy
+	Retries    *int     `yaml:"retries,omitempty"`
+	RetryOn    []string `yaml:"retry_on,omitempty"`
+	Backoff    string   `yaml:"backoff,omitempty"`
+	MaxBackoff string   `yaml:"max_backoff,omitempty"`
+
+	// RemoveHeaders lists inherited headers removed by setting them to null
+	RemoveHeaders []string `yaml:"-"`
+}
+
+// FormField is a field of a multipart form. Written as a string it is the
+// field's value; written as a mapping it can upload files and set the
+// content type of its parts.
+type FormField struct {
+	Value string `yaml:"value,omitempty"`
+	// Files are paths or glob patterns, each file sent as a part of the field
+	Files []string `yaml:"file,omitempty"`
+	// ContentType of the field's parts; file parts default to one guessed
+	// from their extension
+	ContentType string `yaml:"content_type,omitempty"`
+}
+
+// AuthConfig describes how requests are authenticated
+type AuthConfig struct {
+	// Type is one of: none, basic, bearer, apikey, hmac, aws-sigv4, oauth2
+	Type string `yaml:"type"`
+
+	// bearer
+	Token string `yaml:"token,omitempty"`
+
+	// basic
+	Username string `yaml:"username,omitempty"`
+	Password string `yaml:"password,omitempty"`
+
+	// apikey: Key is sent in the header (default) or query parameter Name
+	Key  string `yaml:"key,omitempty"`
+	Name string `yaml:"name,omitempty"`
+	In   string `yaml:"in,omitempty"`
+
+	// hmac: the signature is sent in Header (default X-Signature) and the
+	// key ID, if any, in X-Key-Id
+	Secret    string `yaml:"secret,omitempty"`
+	Algorithm string `yaml:"algorithm,omitempty"`
+	Header    string `yaml:"header,omitempty"`
+	KeyID     string `yaml:"key_id,omitempty"`
+
+	// aws-sigv4
+	AccessKey    string `yaml:"access_key,omitempty"`
+	SecretKey    string `yaml:"secret_key,omitempty"`
+	SessionToken string `yaml:"session_token,omitempty"`
+	Region       string `yaml:"region,omitempty"`
+	Service      string `yaml:"service,omitempty"`
+
+	// oauth2: GrantType is client_credentials (default) or refresh_token;
+	// ClientAuth is basic (default) or body
+	GrantType    string   `yaml:"grant_type,omitempty"`
+	TokenURL     string   `yaml:"token_url,omitempty"`
+	ClientID     string   `yaml:"client_id,omitempty"`
+	ClientSecret string   `yaml:"client_secret,omitempty"`
+	RefreshToken string   `yaml:"refresh_token,omitempty"`
+	Scopes       []string `yaml:"scopes,omitempty"`
+	Audience     string   `yaml:"audience,omitempty"`
+	ClientAuth   string   `yaml:"client_auth,omitempty"`
 }
 
 // QueryParam represents a URL query parameter
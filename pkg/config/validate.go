@@ -0,0 +1,461 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/zqtools/apicli/pkg/template"
+	"gopkg.in/yaml.v3"
+)
+
+// validMethods lists the HTTP methods accepted in request specifications
+var validMethods = map[string]bool{
+	"GET":     true,
+	"HEAD":    true,
+	"POST":    true,
+	"PUT":     true,
+	"PATCH":   true,
+	"DELETE":  true,
+	"OPTIONS": true,
+}
+
+// validAuthTypes lists the supported auth block types
+var validAuthTypes = map[string]bool{
+	"none":      true,
+	"basic":     true,
+	"bearer":    true,
+	"apikey":    true,
+	"hmac":      true,
+	"aws-sigv4": true,
+	"oauth2":    true,
+}
+
+// Issue describes a problem found while validating a configuration
+type Issue struct {
+	File    string
+	Line    int
+	Column  int
+	Message string
+}
+
+// String formats the issue as file:line:col: message
+func (i Issue) String() string {
+	return fmt.Sprintf("%s:%d:%d: %s", i.File, i.Line, i.Column, i.Message)
+}
+
+// paramInfo tracks a declared parameter and whether any template uses it
+type paramInfo struct {
+	name string
+	file string
+	node *yaml.Node
+	used bool
+}
+
+// validator walks the node trees of a configuration and collects issues
+type validator struct {
+	file      string
+	doc       document
+	variables map[string]bool
+	declared  []*paramInfo
+	issues    []Issue
+}
+
+// Validate loads the configuration at path, including every file it
+// includes, and statically checks it for mistakes that would otherwise
+// only show up when an API is called
+func Validate(path string) ([]Issue, error) {
+	config := &Config{
+		Modules:      make(map[string]Module),
+		Environments: make(map[string]Environment),
+		Sources:      make(map[string]string),
+	}
+
+	loader := newIncludeLoader(config)
+	if err := loader.load(path, true); err != nil {
+		return nil, err
+	}
+
+	// Variables defined by any environment may be referenced by templates
+	v := &validator{variables: make(map[string]bool)}
+	for _, env := range config.Environments {
+		for name := range env.Variables {
+			v.variables[name] = true
+		}
+	}
+
+	for _, doc := range loader.documents {
+		v.file = doc.path
+		v.doc = doc
+		root := doc.root
+		if root.Kind == yaml.DocumentNode && len(root.Content) > 0 {
+			root = root.Content[0]
+		}
+		v.validateModules(mappingValue(root, "modules"), nil, nil, false)
+	}
+
+	for _, param := range v.declared {
+		if !param.used {
+			v.issues = append(v.issues, Issue{
+				File:    param.file,
+				Line:    param.node.Line,
+				Column:  param.node.Column,
+				Message: fmt.Sprintf("parameter '%s' is declared but never used", param.name),
+			})
+		}
+	}
+
+	sort.SliceStable(v.issues, func(i, j int) bool {
+		a, b := v.issues[i], v.issues[j]
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Column < b.Column
+	})
+
+	return v.issues, nil
+}
+
+func (v *validator) report(node *yaml.Node, format string, args ...interface{}) {
+	v.reportAt(template.Position{File: v.file, Line: node.Line, Column: node.Column}, format, args...)
+}
+
+func (v *validator) reportAt(pos template.Position, format string, args ...interface{}) {
+	v.issues = append(v.issues, Issue{
+		File:    pos.File,
+		Line:    pos.Line,
+		Column:  pos.Column,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// validateModules checks every module in a modules mapping. chain holds the
+// parameters declared by the enclosing modules and hasBaseURL reports whether
+// one of them sets a base URL.
+func (v *validator) validateModules(node *yaml.Node, path []string, chain []*paramInfo, hasBaseURL bool) {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return
+	}
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		name := node.Content[i].Value
+		moduleNode := node.Content[i+1]
+		modulePath := append(append([]string{}, path...), name)
+
+		moduleChain := v.validateParams(mappingValue(moduleNode, "params"), modulePath, chain)
+
+		if helper := mappingValue(moduleNode, "credential_helper"); helper != nil &&
+			(helper.Kind != yaml.ScalarNode || strings.TrimSpace(helper.Value) == "") {
+			v.report(helper, "credential_helper of module '%s' must be a command", strings.Join(modulePath, "."))
+		}
+
+		moduleBaseURL := hasBaseURL
+		if request := mappingValue(moduleNode, "request"); request != nil {
+			v.validateTemplates(request, "module request", moduleChain)
+			v.validateRequestOptions(request)
+			if baseURL := mappingValue(request, "base_url"); baseURL != nil {
+				if err := checkURL(baseURL.Value); err != nil {
+					v.report(baseURL, "malformed base_url '%s': %v", baseURL.Value, err)
+				}
+				moduleBaseURL = true
+			}
+		}
+
+		v.validateModules(mappingValue(moduleNode, "modules"), modulePath, moduleChain, moduleBaseURL)
+
+		apis := mappingValue(moduleNode, "apis")
+		if apis == nil || apis.Kind != yaml.MappingNode {
+			continue
+		}
+		for j := 0; j+1 < len(apis.Content); j += 2 {
+			v.validateAPI(apis.Content[j].Value, apis.Content[j+1], modulePath, moduleChain, moduleBaseURL)
+		}
+	}
+}
+
+// validateAPI checks a single API specification
+func (v *validator) validateAPI(name string, node *yaml.Node, modulePath []string, chain []*paramInfo, hasBaseURL bool) {
+	apiChain := v.validateParams(mappingValue(node, "params"), append(modulePath, name), chain)
+
+	request := mappingValue(node, "request")
+	if request == nil {
+		v.report(node, "API '%s' has no request", name)
+		return
+	}
+
+	method := mappingValue(request, "method")
+	switch {
+	case method == nil:
+		v.report(request, "API '%s' has no method", name)
+	case !validMethods[method.Value]:
+		v.report(method, "invalid HTTP method '%s'", method.Value)
+	}
+
+	rawURL := mappingValue(request, "url")
+	switch {
+	case rawURL == nil || rawURL.Value == "":
+		if !hasBaseURL {
+			v.report(request, "API '%s' has no url", name)
+		}
+	case IsRelativeURL(rawURL.Value):
+		if !hasBaseURL {
+			v.report(rawURL, "relative url '%s' but no module sets base_url", rawURL.Value)
+		}
+	default:
+		if err := checkURL(rawURL.Value); err != nil {
+			v.report(rawURL, "malformed url '%s': %v", rawURL.Value, err)
+		}
+	}
+
+	bodies := 0
+	for _, key := range []string{"body", "json", "body_file", "form"} {
+		if mappingValue(request, key) != nil {
+			bodies++
+		}
+	}
+	if bodies > 1 {
+		v.report(request, "API '%s' sets more than one of body, json, body_file and form", name)
+	}
+
+	if format := mappingValue(request, "format"); format != nil && format.Value != "json" && format.Value != "text" {
+		v.report(format, "unknown body format '%s' (expected json or text)", format.Value)
+	}
+
+	v.validateTemplates(request, "request", apiChain)
+	v.validateRequestOptions(request)
+	v.validateForm(mappingValue(request, "form"))
+	v.validateCaptures(mappingValue(node, "capture"))
+}
+
+// validateForm checks the keys of form fields written as mappings
+func (v *validator) validateForm(node *yaml.Node) {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		field := node.Content[i+1]
+		if field.Kind != yaml.MappingNode {
+			continue
+		}
+		for j := 0; j+1 < len(field.Content); j += 2 {
+			switch key := field.Content[j]; key.Value {
+			case "value", "file", "content_type":
+			default:
+				v.report(key, "unknown key '%s' in form field '%s' (expected value, file or content_type)", key.Value, node.Content[i].Value)
+			}
+		}
+	}
+}
+
+// validateCaptures checks the capture block of an API
+func (v *validator) validateCaptures(node *yaml.Node) {
+	if node == nil {
+		return
+	}
+	if node.Kind != yaml.MappingNode {
+		v.report(node, "capture must be a mapping of variable names to paths")
+		return
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		var capture CaptureSpec
+		if err := node.Content[i+1].Decode(&capture); err != nil {
+			v.report(node.Content[i+1], "capture '%s': %v", node.Content[i].Value, err)
+			continue
+		}
+		if err := capture.Check(); err != nil {
+			v.report(node.Content[i+1], "capture '%s': %v", node.Content[i].Value, err)
+		}
+	}
+}
+
+// validateRequestOptions checks the settings shared by module and API request blocks
+func (v *validator) validateRequestOptions(request *yaml.Node) {
+	for _, setting := range []string{"timeout", "backoff", "max_backoff"} {
+		if node := mappingValue(request, setting); node != nil {
+			if _, err := parseDuration(setting, node.Value); err != nil {
+				v.report(node, "%v", err)
+			}
+		}
+	}
+	if node := mappingValue(request, "retries"); node != nil {
+		if n, err := strconv.Atoi(node.Value); err != nil || n < 0 {
+			v.report(node, "retries must be a non-negative integer, not '%s'", node.Value)
+		}
+	}
+	if node := mappingValue(request, "retry_on"); node != nil {
+		var conditions []string
+		if err := node.Decode(&conditions); err != nil {
+			v.report(node, "retry_on must be a list: %v", err)
+		} else {
+			policy := &RetryPolicy{statuses: make(map[int]bool), classes: make(map[int]bool)}
+			for _, condition := range conditions {
+				if err := policy.addCondition(condition); err != nil {
+					v.report(node, "%v", err)
+				}
+			}
+		}
+	}
+
+	if auth := mappingValue(request, "auth"); auth != nil {
+		authType := mappingValue(auth, "type")
+		switch {
+		case authType == nil:
+			v.report(auth, "auth has no type")
+		case !validAuthTypes[authType.Value]:
+			v.report(authType, "unknown auth type '%s'", authType.Value)
+		default:
+			var config AuthConfig
+			if err := auth.Decode(&config); err != nil {
+				v.report(auth, "invalid auth: %v", err)
+			} else if err := config.Check(); err != nil {
+				v.report(auth, "%v", err)
+			}
+		}
+	}
+}
+
+// validateParams checks a params sequence and returns the chain extended
+// with the parameters it declares
+func (v *validator) validateParams(node *yaml.Node, path []string, chain []*paramInfo) []*paramInfo {
+	extended := append([]*paramInfo{}, chain...)
+	if node == nil || node.Kind != yaml.SequenceNode {
+		return extended
+	}
+
+	for _, item := range node.Content {
+		nameNode := mappingValue(item, "name")
+		if nameNode == nil || nameNode.Value == "" {
+			v.report(item, "parameter in '%s' has no name", strings.Join(path, "."))
+			continue
+		}
+
+		if typeNode := mappingValue(item, "type"); typeNode != nil && !template.IsKnownType(typeNode.Value) {
+			v.report(typeNode, "parameter '%s' has unknown type '%s'", nameNode.Value, typeNode.Value)
+		}
+
+		var def ParamDef
+		if err := item.Decode(&def); err != nil {
+			v.report(item, "parameter '%s': %v", nameNode.Value, err)
+		} else if err := def.CheckDefinition(); err != nil {
+			v.report(item, "parameter '%s': %v", nameNode.Value, err)
+		}
+
+		for _, existing := range extended {
+			if existing.name == nameNode.Value {
+				v.report(nameNode, "parameter '%s' is already declared along the module chain at %s:%d",
+					nameNode.Value, existing.file, existing.node.Line)
+				break
+			}
+		}
+
+		param := &paramInfo{name: nameNode.Value, file: v.file, node: nameNode}
+		v.declared = append(v.declared, param)
+		extended = append(extended, param)
+	}
+
+	return extended
+}
+
+// validateTemplates checks every template string below node, reporting
+// references that resolve to neither a parameter nor an environment variable
+func (v *validator) validateTemplates(node *yaml.Node, context string, chain []*paramInfo) {
+	for _, scalar := range scalarNodes(node) {
+		t, err := template.Compile(scalar.Value)
+		if err != nil {
+			v.report(scalar, "invalid template in %s: %v", context, err)
+			continue
+		}
+		// Recompile with positions so issues point at the placeholder itself
+		if located, err := template.CompileAt(scalar.Value, v.doc.locator(scalar)); err == nil {
+			t = located
+		}
+		for _, ref := range t.References() {
+			if v.markUsed(ref.Name, chain) {
+				continue
+			}
+			if v.variables[ref.Name] || ref.Name == template.CapturedVariables || ref.Name == template.HelperCredentials {
+				continue
+			}
+			v.reportAt(ref.Pos, "undefined variable '${%s}' in %s", ref.Name, context)
+		}
+	}
+}
+
+// markUsed marks the parameter named ref as used and reports whether it was found
+func (v *validator) markUsed(ref string, chain []*paramInfo) bool {
+	found := false
+	for _, param := range chain {
+		if param.name == ref {
+			param.used = true
+			found = true
+		}
+	}
+	return found
+}
+
+// checkURL verifies that a URL template is well formed once its variables are substituted
+func checkURL(tmpl string) error {
+	substituted := template.ReplacePlaceholders(tmpl, func(string) string { return "x" })
+
+	parsed, err := url.Parse(substituted)
+	if err != nil {
+		return err
+	}
+
+	// A URL starting with a variable gets its scheme and host from that variable
+	if strings.HasPrefix(tmpl, "${") {
+		return nil
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return fmt.Errorf("scheme must be http or https")
+	}
+	if parsed.Host == "" {
+		return fmt.Errorf("missing host")
+	}
+	return nil
+}
+
+// mappingValue returns the value node for key in a mapping node, or nil
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// scalarNodes returns every scalar value below node (mapping keys excluded)
+func scalarNodes(node *yaml.Node) []*yaml.Node {
+	if node == nil {
+		return nil
+	}
+
+	switch node.Kind {
+	case yaml.ScalarNode:
+		return []*yaml.Node{node}
+	case yaml.MappingNode:
+		var scalars []*yaml.Node
+		for i := 1; i < len(node.Content); i += 2 {
+			scalars = append(scalars, scalarNodes(node.Content[i])...)
+		}
+		return scalars
+	case yaml.SequenceNode, yaml.DocumentNode:
+		var scalars []*yaml.Node
+		for _, child := range node.Content {
+			scalars = append(scalars, scalarNodes(child)...)
+		}
+		return scalars
+	case yaml.AliasNode:
+		return scalarNodes(node.Alias)
+	}
+	return nil
+}
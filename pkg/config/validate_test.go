@@ -0,0 +1,340 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeFiles creates files, given by path relative to a new temporary
+// directory, and returns the directory
+func writeFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// validate validates the apis.yaml in dir and returns its issues with
+// paths relative to dir
+func validate(t *testing.T, dir string) []string {
+	t.Helper()
+	issues, err := Validate(filepath.Join(dir, "apis.yaml"))
+	if err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	var found []string
+	for _, issue := range issues {
+		found = append(found, strings.ReplaceAll(issue.String(), dir+string(filepath.Separator), ""))
+	}
+	return found
+}
+
+func TestValidateSampleConfig(t *testing.T) {
+	issues, err := Validate("../../apis.yaml")
+	if err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	for _, issue := range issues {
+		t.Errorf("apis.yaml: %s", issue)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		issues []string
+	}{
+		{
+			name: "valid",
+			config: `
+environments:
+  dev:
+    variables:
+      host: https://dev.test
+modules:
+  users:
+    params:
+      - name: token
+    request:
+      base_url: ${host}
+      headers:
+        Authorization: Bearer ${token}
+    apis:
+      get:
+        params:
+          - name: id
+            type: integer
+        request:
+          method: GET
+          url: /users/${id}?trace=${vars.trace_id}&u=${credentials.user}
+`,
+		},
+		{
+			name: "unused and undefined",
+			config: `
+modules:
+  users:
+    apis:
+      get:
+        params:
+          - name: id
+        request:
+          method: GET
+          url: https://api.test/users/${user_id}
+`,
+			issues: []string{
+				"apis.yaml:7:19: parameter 'id' is declared but never used",
+				"apis.yaml:10:39: undefined variable '${user_id}' in request",
+			},
+		},
+		{
+			name: "parameter declared along the module chain",
+			config: `
+modules:
+  users:
+    params:
+      - name: id
+    apis:
+      get:
+        params:
+          - name: id
+        request:
+          method: GET
+          url: https://api.test/users/${id}
+`,
+			issues: []string{
+				"apis.yaml:9:19: parameter 'id' is already declared along the module chain at apis.yaml:5",
+			},
+		},
+		{
+			name: "request",
+			config: `
+modules:
+  users:
+    apis:
+      none: {}
+      nomethod:
+        request:
+          url: https://api.test/
+      method:
+        request:
+          method: FETCH
+          url: https://api.test/
+      nourl:
+        request:
+          method: GET
+      relative:
+        request:
+          method: GET
+          url: /users
+      scheme:
+        request:
+          method: GET
+          url: ftp://api.test/
+      bodies:
+        request:
+          method: POST
+          url: https://api.test/
+          body: x
+          form:
+            a: b
+      format:
+        request:
+          method: POST
+          url: https://api.test/
+          format: xml
+`,
+			issues: []string{
+				"apis.yaml:5:13: API 'none' has no request",
+				"apis.yaml:8:11: API 'nomethod' has no method",
+				"apis.yaml:11:19: invalid HTTP method 'FETCH'",
+				"apis.yaml:15:11: API 'nourl' has no url",
+				"apis.yaml:19:16: relative url '/users' but no module sets base_url",
+				"apis.yaml:23:16: malformed url 'ftp://api.test/': scheme must be http or https",
+				"apis.yaml:26:11: API 'bodies' sets more than one of body, json, body_file and form",
+				"apis.yaml:35:19: unknown body format 'xml' (expected json or text)",
+			},
+		},
+		{
+			name: "templates",
+			config: `
+modules:
+  users:
+    request:
+      base_url: https://api.test
+      headers:
+        X-Trace: ${nope(}
+    apis:
+      get:
+        request:
+          method: GET
+          url: /users/${uuid()|base64}?d=${now(1, 2)}
+`,
+			issues: []string{
+				"apis.yaml:7:18: invalid template in module request: parsing '${nope(}': unexpected end of expression 'nope('",
+				"apis.yaml:12:16: invalid template in request: in '${now(1, 2)}': function 'now' takes 0 to 1 arguments",
+			},
+		},
+		{
+			name: "request options",
+			config: `
+modules:
+  users:
+    request:
+      base_url: https://api.test
+      timeout: soon
+      retries: -1
+      retry_on: [503, 6xx]
+      auth:
+        type: magic
+    apis:
+      get:
+        request:
+          method: GET
+          url: /
+          backoff: 1
+          auth:
+            token: x
+      post:
+        request:
+          method: POST
+          url: /
+          auth:
+            type: apikey
+            key: k
+`,
+			issues: []string{
+				`apis.yaml:6:16: invalid timeout 'soon': time: invalid duration "soon"`,
+				"apis.yaml:7:16: retries must be a non-negative integer, not '-1'",
+				"apis.yaml:8:17: invalid retry_on entry '6xx' (expected a status code, a class such as 5xx, or connection)",
+				"apis.yaml:10:15: unknown auth type 'magic'",
+				`apis.yaml:16:20: invalid backoff '1': time: missing unit in duration "1"`,
+				"apis.yaml:18:13: auth has no type",
+				"apis.yaml:24:13: apikey auth requires name",
+			},
+		},
+		{
+			name: "params",
+			config: `
+modules:
+  users:
+    params:
+      - type: string
+      - name: kind
+        type: uuid
+      - name: code
+        pattern: "[a-"
+      - name: limit
+        type: integer
+        min: 10
+        max: 1
+      - name: day
+        type: date
+        format: yyyy
+      - name: state
+        enum: [a, b]
+        default: c
+      - name: label
+        min: 1
+    apis:
+      get:
+        request:
+          method: GET
+          url: https://api.test/${kind}/${code}/${limit}/${day}/${state}/${label}
+`,
+			issues: []string{
+				"apis.yaml:5:9: parameter in 'users' has no name",
+				"apis.yaml:7:15: parameter 'kind' has unknown type 'uuid'",
+				"apis.yaml:8:9: parameter 'code': invalid pattern '[a-': error parsing regexp: missing closing ]: `[a-`",
+				"apis.yaml:10:9: parameter 'limit': min 10 is greater than max 1",
+				"apis.yaml:14:9: parameter 'day': invalid date format 'yyyy'",
+				"apis.yaml:17:9: parameter 'state': default 'c': value must be one of: a, b",
+				"apis.yaml:20:9: parameter 'label': min and max only apply to integer and number parameters",
+			},
+		},
+		{
+			name: "form, capture and credential helper",
+			config: `
+modules:
+  users:
+    credential_helper: ""
+    apis:
+      upload:
+        request:
+          method: POST
+          url: https://api.test/upload
+          form:
+            doc:
+              path: a.txt
+        capture:
+          id: $.body.id
+          token:
+            path: $.response.token
+          session:
+            path: $.headers.X-Session
+            ttl: forever
+`,
+			issues: []string{
+				"apis.yaml:4:24: credential_helper of module 'users' must be a command",
+				"apis.yaml:12:15: unknown key 'path' in form field 'doc' (expected value, file or content_type)",
+				"apis.yaml:16:13: capture 'token': path '$.response.token' must start with $.body, $.headers, $.status",
+				"apis.yaml:18:13: capture 'session': invalid ttl 'forever': time: invalid duration \"forever\"",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := writeFiles(t, map[string]string{"apis.yaml": tt.config})
+			issues := validate(t, dir)
+			if strings.Join(issues, "\n") != strings.Join(tt.issues, "\n") {
+				t.Errorf("issues:\n%s\nwant:\n%s", strings.Join(issues, "\n"), strings.Join(tt.issues, "\n"))
+			}
+		})
+	}
+}
+
+func TestValidateIncludes(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"apis.yaml": `
+include:
+  - modules/*.yaml
+environments:
+  dev:
+    variables:
+      host: https://dev.test
+`,
+		"modules/users.yaml": `
+modules:
+  users:
+    params:
+      - name: id
+    apis:
+      get:
+        request:
+          method: GET
+          url: ${host}/users/${name}
+`,
+	})
+
+	// Issues point into the included file, and variables of the including
+	// file's environments are known there
+	want := []string{
+		"modules/users.yaml:5:15: parameter 'id' is declared but never used",
+		"modules/users.yaml:10:30: undefined variable '${name}' in request",
+	}
+	if issues := validate(t, dir); strings.Join(issues, "\n") != strings.Join(want, "\n") {
+		t.Errorf("issues:\n%s\nwant:\n%s", strings.Join(issues, "\n"), strings.Join(want, "\n"))
+	}
+}
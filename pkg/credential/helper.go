@@ -0,0 +1,94 @@
+package credential
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// Request describes the call a helper is asked to supply credentials for
+type Request struct {
+	Module   string `json:"module"`
+	API      string `json:"api"`
+	Protocol string `json:"protocol"`
+	Host     string `json:"host"`
+}
+
+// cache holds helper results for the rest of the process, so a helper runs
+// at most once per module and host
+var cache = struct {
+	sync.Mutex
+	results map[string]map[string]string
+}{results: make(map[string]map[string]string)}
+
+// Get runs a credential helper and returns the credentials it printed. The
+// helper receives req as JSON on stdin and prints a JSON object of names to
+// values on stdout. The command is run by the shell, so paths and arguments
+// can be quoted, and its stderr goes to the user's, where it can prompt.
+func Get(ctx context.Context, command string, req Request) (map[string]string, error) {
+	key := command + "\n" + req.Module + "\n" + req.Protocol + "://" + req.Host
+	cache.Lock()
+	defer cache.Unlock()
+	if result, ok := cache.results[key]; ok {
+		return result, nil
+	}
+
+	result, err := run(ctx, command, req)
+	if err != nil {
+		return nil, err
+	}
+	cache.results[key] = result
+	return result, nil
+}
+
+func run(ctx context.Context, command string, req Request) (map[string]string, error) {
+	if strings.TrimSpace(command) == "" {
+		return nil, fmt.Errorf("credential helper command is empty")
+	}
+
+	input, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	var stdout bytes.Buffer
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = &stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = os.Environ()
+
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("credential helper '%s' failed: %w", command, err)
+	}
+
+	var values map[string]interface{}
+	decoder := json.NewDecoder(&stdout)
+	decoder.UseNumber()
+	if err := decoder.Decode(&values); err != nil {
+		return nil, fmt.Errorf("credential helper '%s' did not print a JSON object: %v", command, err)
+	}
+	if len(values) == 0 {
+		return nil, fmt.Errorf("credential helper '%s' returned no credentials", command)
+	}
+
+	result := make(map[string]string, len(values))
+	for name, value := range values {
+		switch v := value.(type) {
+		case string:
+			result[name] = v
+		case json.Number:
+			result[name] = v.String()
+		case bool:
+			result[name] = fmt.Sprint(v)
+		default:
+			return nil, fmt.Errorf("credential helper '%s' returned a non-scalar value for '%s'", command, name)
+		}
+	}
+	return result, nil
+}
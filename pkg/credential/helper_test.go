@@ -0,0 +1,66 @@
+package credential
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeHelper writes an executable shell script into a directory whose name
+// contains a space and returns its path
+func writeHelper(t *testing.T, script string) string {
+	t.Helper()
+	dir := filepath.Join(t.TempDir(), "my helpers")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "helper.sh")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestGetQuotedCommand(t *testing.T) {
+	// The helper echoes its argument and the host it was asked about
+	path := writeHelper(t, `host=$(sed 's/.*"host":"\([^"]*\)".*/\1/')
+printf '{"role": "%s", "host": "%s", "port": 8443, "admin": true}' "$1" "$host"
+`)
+	command := `"` + path + `" 'billing team'`
+
+	values, err := Get(context.Background(), command, Request{Module: "m", Protocol: "https", Host: "api.test"})
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	want := map[string]string{"role": "billing team", "host": "api.test", "port": "8443", "admin": "true"}
+	for name, value := range want {
+		if values[name] != value {
+			t.Errorf("%s = %q, want %q", name, values[name], value)
+		}
+	}
+}
+
+func TestGetErrors(t *testing.T) {
+	tests := []struct {
+		script string
+		want   string
+	}{
+		{"exit 3\n", "failed: exit status 3"},
+		{"echo not json\n", "did not print a JSON object"},
+		{"echo '{}'\n", "returned no credentials"},
+		{`echo '{"a": {"b": 1}}'` + "\n", "returned a non-scalar value for 'a'"},
+	}
+	for _, tt := range tests {
+		command := `"` + writeHelper(t, tt.script) + `"`
+		_, err := Get(context.Background(), command, Request{Module: "m", Host: "api.test"})
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("script %q: error = %v, want it to contain %q", tt.script, err, tt.want)
+		}
+	}
+
+	if _, err := Get(context.Background(), " ", Request{}); err == nil {
+		t.Error("empty command: want an error")
+	}
+}
@@ -45,7 +45,22 @@ if err != nil {
 return fmt.Errorf("serializing history: %w", err)
 }
 
-if err := os.WriteFile(m.historyPath, data, 0644); err != nil {
+// Write a private temporary file and rename it over the history, so an
+// interrupted write never leaves a truncated file behind. History may
+// contain request details, so it is only readable by the user.
+tmp, err := os.CreateTemp(filepath.Dir(m.historyPath), ".history-*.json")
+if err != nil {
+return fmt.Errorf("writing history file: %w", err)
+}
+defer os.Remove(tmp.Name())
+if _, err := tmp.Write(data); err != nil {
+tmp.Close()
+return fmt.Errorf("writing history file: %w", err)
+}
+if err := tmp.Close(); err != nil {
+return fmt.Errorf("writing history file: %w", err)
+}
+if err := os.Rename(tmp.Name(), m.historyPath); err != nil {
 return fmt.Errorf("writing history file: %w", err)
 }
 
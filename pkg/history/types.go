@@ -13,11 +13,19 @@ ID          string            `json:"id"`
 Timestamp   time.Time         `json:"timestamp"`
 Module      string            `json:"module"`
 API         string            `json:"api"`
+Environment string            `json:"environment,omitempty"`
+Context     string            `json:"context,omitempty"`
+Attempts    int               `json:"attempts,omitempty"`
+// State is StateCancelled for calls interrupted before a response was read
+State       string            `json:"state,omitempty"`
 Parameters  map[string]string `json:"parameters"`
 Request     Request           `json:"request"`
 Response    Response          `json:"response,omitempty"`
 }
 
+// StateCancelled marks a call that was cancelled, e.g. with Ctrl-C
+const StateCancelled = "cancelled"
+
 // GetCommandLine returns the complete command line for this entry
 func (e *Entry) GetCommandLine() string {
 var params []string
@@ -25,6 +33,9 @@ for k, v := range e.Parameters {
 params = append(params, fmt.Sprintf("--%s %q", k, v))
 }
 sort.Strings(params) // Sort for consistent output
+if e.Environment != "" {
+params = append([]string{fmt.Sprintf("--env %q", e.Environment)}, params...)
+}
 return fmt.Sprintf("call %s.%s %s", e.Module, e.API, strings.Join(params, " "))
 }
 
@@ -43,6 +54,13 @@ type Response struct {
 StatusCode int               `json:"status_code"`
 Headers    map[string]string `json:"headers,omitempty"`
 Body       string           `json:"body"`
+// Streamed responses keep a bounded transcript in Body; Events counts the
+// server-sent events or JSON lines received
+Streamed   bool              `json:"streamed,omitempty"`
+Events     int               `json:"events,omitempty"`
+// Downloaded responses are saved to File instead of Body
+File       string            `json:"file,omitempty"`
+Size       int64             `json:"size,omitempty"`
 }
 
 // History represents the collection of history entries
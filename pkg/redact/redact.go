@@ -0,0 +1,148 @@
+package redact
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// Mask replaces sensitive values wherever they would be displayed or stored
+const Mask = "****"
+
+// DefaultSensitiveHeaders lists headers whose values are always masked
+var DefaultSensitiveHeaders = []string{
+	"Authorization",
+	"Proxy-Authorization",
+	"Cookie",
+	"Set-Cookie",
+	"X-Api-Key",
+	"X-Auth-Token",
+}
+
+// Redactor masks secret parameter values and sensitive headers
+type Redactor struct {
+	values  []string
+	headers map[string]bool
+}
+
+// New creates a redactor that masks the default sensitive headers plus the given ones
+func New(extraHeaders []string) *Redactor {
+	r := &Redactor{headers: make(map[string]bool)}
+	for _, name := range DefaultSensitiveHeaders {
+		r.headers[http.CanonicalHeaderKey(name)] = true
+	}
+	for _, name := range extraHeaders {
+		r.headers[http.CanonicalHeaderKey(name)] = true
+	}
+	return r
+}
+
+// AddValue registers a secret value to be masked wherever it appears
+func (r *Redactor) AddValue(value string) {
+	if value == "" {
+		return
+	}
+	// Also mask the escaped forms that end up in URLs and JSON strings
+	r.values = append(r.values, value)
+	escapedForms := []string{url.QueryEscape(value), url.PathEscape(value), jsonEscape(value, true), jsonEscape(value, false)}
+	for _, escaped := range escapedForms {
+		if escaped != value && !r.has(escaped) {
+			r.values = append(r.values, escaped)
+		}
+	}
+	// Replace longer values first so a secret containing another is fully masked
+	sort.SliceStable(r.values, func(i, j int) bool {
+		return len(r.values[i]) > len(r.values[j])
+	})
+}
+
+// has reports whether value is already masked
+func (r *Redactor) has(value string) bool {
+	for _, v := range r.values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonEscape returns value as it appears inside a JSON string. Bodies
+// rendered from templates leave &, < and > as they are, while json.Marshal
+// escapes them, so both forms are needed.
+func jsonEscape(value string, escapeHTML bool) string {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(escapeHTML)
+	if err := encoder.Encode(value); err != nil {
+		return value
+	}
+	encoded := strings.TrimSuffix(buf.String(), "\n")
+	return encoded[1 : len(encoded)-1]
+}
+
+// IsSensitiveHeader reports whether the named header's value must be masked
+func (r *Redactor) IsSensitiveHeader(name string) bool {
+	return r.headers[http.CanonicalHeaderKey(name)]
+}
+
+// String masks every registered secret value occurring in s
+func (r *Redactor) String(s string) string {
+	if r == nil {
+		return s
+	}
+	for _, value := range r.values {
+		s = strings.ReplaceAll(s, value, Mask)
+	}
+	return s
+}
+
+// Header returns the value to display for a header, masking it entirely
+// when the header is sensitive
+func (r *Redactor) Header(name, value string) string {
+	if r == nil {
+		return value
+	}
+	if r.IsSensitiveHeader(name) {
+		return Mask
+	}
+	return r.String(value)
+}
+
+// Map returns a copy of m with every value masked by String
+func (r *Redactor) Map(m map[string]string) map[string]string {
+	if m == nil {
+		return nil
+	}
+	masked := make(map[string]string, len(m))
+	for k, v := range m {
+		masked[k] = r.String(v)
+	}
+	return masked
+}
+
+// Dump masks a raw HTTP request or response dump: sensitive header lines
+// are masked entirely and secret values are masked everywhere
+func (r *Redactor) Dump(dump string) string {
+	if r == nil {
+		return dump
+	}
+
+	lines := strings.Split(dump, "\n")
+	for i, line := range lines {
+		// Headers end at the first blank line
+		if strings.TrimRight(line, "\r") == "" {
+			break
+		}
+		if name, _, ok := strings.Cut(line, ":"); ok && r.IsSensitiveHeader(strings.TrimSpace(name)) {
+			lines[i] = name + ": " + Mask
+			if strings.HasSuffix(line, "\r") {
+				lines[i] += "\r"
+			}
+		}
+	}
+
+	return r.String(strings.Join(lines, "\n"))
+}
@@ -0,0 +1,59 @@
+package redact_test
+
+import (
+	"encoding/json"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/zqtools/apicli/pkg/redact"
+	"github.com/zqtools/apicli/pkg/template"
+)
+
+const secret = `p"w&d<1>`
+
+func TestStringMasksEscapedForms(t *testing.T) {
+	r := redact.New(nil)
+	r.AddValue(secret)
+
+	marshaled, _ := json.Marshal(map[string]string{"password": secret})
+	tests := map[string]string{
+		"plain":        "password=" + secret,
+		"query":        "https://api.test/?pw=" + url.QueryEscape(secret),
+		"path":         "https://api.test/" + url.PathEscape(secret),
+		"json.Marshal": string(marshaled),
+	}
+	for name, s := range tests {
+		got := r.String(s)
+		// Every form of the secret keeps its 1, which nothing else contains
+		if strings.Contains(got, "1") || !strings.Contains(got, redact.Mask) {
+			t.Errorf("%s: String(%q) = %q, want the secret masked", name, s, got)
+		}
+	}
+}
+
+func TestStringMasksRenderedJSONBody(t *testing.T) {
+	r := redact.New(nil)
+	r.AddValue(secret)
+
+	renderer := template.NewRenderer(map[string]interface{}{"password": secret})
+	body, err := renderer.RenderJSON(`{"user": "ann", "password": "${password}"}`)
+	if err != nil {
+		t.Fatalf("RenderJSON: %v", err)
+	}
+	if got, want := r.String(body), `{"user": "ann", "password": "****"}`; got != want {
+		t.Errorf("String(%q) = %q, want %q", body, got, want)
+	}
+}
+
+func TestHeaderMasksSensitiveHeaders(t *testing.T) {
+	r := redact.New([]string{"X-Tenant-Secret"})
+	for _, name := range []string{"authorization", "X-Tenant-Secret"} {
+		if got := r.Header(name, "anything"); got != redact.Mask {
+			t.Errorf("Header(%q) = %q, want %q", name, got, redact.Mask)
+		}
+	}
+	if got := r.Header("Accept", "application/json"); got != "application/json" {
+		t.Errorf("Header(Accept) = %q, want it unchanged", got)
+	}
+}
@@ -0,0 +1,78 @@
+package secrets
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func TestPBKDF2(t *testing.T) {
+	// PBKDF2-HMAC-SHA256 vectors from RFC 7914 section 11 and the SHA-256
+	// counterparts of the RFC 6070 vectors
+	tests := []struct {
+		passphrase, salt string
+		iterations       int
+		key              string
+	}{
+		{"passwd", "salt", 1, "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783"},
+		{"Password", "NaCl", 80000, "4ddcd8f60b98be21830cee5ef22701f9641a4418d04c0414aeff08876b34ab56a1d425a1225833549adb841b51c9b3176a272bdebba1d078478f62b397f33c8d"},
+		{"password", "salt", 1, "120fb6cffcf8b32c43e7225256c4f837a86548c92ccc35480805987cb70be17b"},
+		{"password", "salt", 2, "ae4d0c95af6b46d32d0adff928f06dd02a303f8ef3c251dfd6e2d85a95474c43"},
+		{"password", "salt", 4096, "c5e478d59288c841aa530db6845c4c8d962893a001ce4e11a4963873aa98134a"},
+		{"passwordPASSWORDpassword", "saltSALTsaltSALTsaltSALTsaltSALTsalt", 4096, "348c89dbcbd32b2f32d814b8116e84cf2b17347ebc1800181c4e2a1fb8dd53e1c635518c7dac47e9"},
+		{"pass\x00word", "sa\x00lt", 4096, "89b69d0516f829893c696226650a8687"},
+	}
+	for _, tt := range tests {
+		want, _ := hex.DecodeString(tt.key)
+		got := pbkdf2([]byte(tt.passphrase), []byte(tt.salt), tt.iterations, len(want))
+		if !bytes.Equal(got, want) {
+			t.Errorf("pbkdf2(%q, %q, %d, %d) = %x, want %x", tt.passphrase, tt.salt, tt.iterations, len(want), got, want)
+		}
+	}
+}
+
+func TestSealOpen(t *testing.T) {
+	key := pbkdf2([]byte("right"), []byte("salt"), 1, keySize)
+	plaintext := []byte(`{"token":"s3cr3t"}`)
+
+	nonce, ciphertext, err := seal(key, plaintext)
+	if err != nil {
+		t.Fatalf("seal: %v", err)
+	}
+	if bytes.Contains(ciphertext, []byte("s3cr3t")) {
+		t.Errorf("ciphertext contains the plaintext")
+	}
+	got, err := open(key, nonce, ciphertext)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	if !bytes.Equal(got, plaintext) {
+		t.Errorf("open = %q, want %q", got, plaintext)
+	}
+
+	// A fresh nonce makes every sealing of the same plaintext different
+	nonce2, ciphertext2, err := seal(key, plaintext)
+	if err != nil {
+		t.Fatalf("seal: %v", err)
+	}
+	if bytes.Equal(nonce, nonce2) || bytes.Equal(ciphertext, ciphertext2) {
+		t.Errorf("sealing twice reused the nonce")
+	}
+}
+
+func TestOpenWithWrongKey(t *testing.T) {
+	key := pbkdf2([]byte("right"), []byte("salt"), 1, keySize)
+	nonce, ciphertext, err := seal(key, []byte("value"))
+	if err != nil {
+		t.Fatalf("seal: %v", err)
+	}
+
+	wrong := pbkdf2([]byte("wrong"), []byte("salt"), 1, keySize)
+	if _, err := open(wrong, nonce, ciphertext); err == nil {
+		t.Errorf("open with the wrong key succeeded")
+	}
+	ciphertext[0] ^= 1
+	if _, err := open(key, nonce, ciphertext); err == nil {
+		t.Errorf("open of tampered ciphertext succeeded")
+	}
+}
@@ -0,0 +1,238 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+)
+
+// PassphraseEnv names the environment variable the passphrase is read from
+// before falling back to a prompt
+const PassphraseEnv = "APICLI_SECRETS_PASSPHRASE"
+
+// Secret is a stored value
+type Secret struct {
+	Value     string    `json:"value"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// file is the on-disk form of the store. Only the KDF parameters are in the
+// clear; the names and values are encrypted together.
+type file struct {
+	Version    int    `json:"version"`
+	Salt       []byte `json:"salt"`
+	Iterations int    `json:"iterations"`
+	Nonce      []byte `json:"nonce"`
+	Data       []byte `json:"data"`
+}
+
+// Passphrase supplies the passphrase of the store. create is true when
+// the store does not exist yet, so the caller may ask for confirmation.
+// A prompt should give up when ctx is cancelled.
+type Passphrase func(ctx context.Context, create bool) (string, error)
+
+// Store keeps secrets in an encrypted file in the apicli directory. It is
+// decrypted on first use and kept in memory for the rest of the process.
+type Store struct {
+	path       string
+	passphrase Passphrase
+
+	loaded     bool
+	key        []byte
+	salt       []byte
+	iterations int
+	secrets    map[string]Secret
+}
+
+// NewStore creates a store for secrets kept under baseDir. passphrase is
+// only called when the store is first read or written.
+func NewStore(baseDir string, passphrase Passphrase) *Store {
+	return &Store{path: filepath.Join(baseDir, "secrets"), passphrase: passphrase}
+}
+
+// Path returns the location of the store's file
+func (s *Store) Path() string {
+	return s.path
+}
+
+// load decrypts the store, or prepares an empty one if it does not exist
+func (s *Store) load(ctx context.Context) error {
+	if s.loaded {
+		return nil
+	}
+
+	info, err := os.Stat(s.path)
+	if os.IsNotExist(err) {
+		return s.create(ctx)
+	}
+	if err != nil {
+		return fmt.Errorf("reading secrets: %w", err)
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm()&0077 != 0 {
+		return fmt.Errorf("refusing to load %s: permissions %04o are too open, run 'chmod 600 %s'",
+			s.path, info.Mode().Perm(), s.path)
+	}
+
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return fmt.Errorf("reading secrets: %w", err)
+	}
+	var stored file
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return fmt.Errorf("parsing %s: %w", s.path, err)
+	}
+	if stored.Version != 1 || stored.Iterations <= 0 {
+		return fmt.Errorf("unsupported secrets file %s", s.path)
+	}
+
+	passphrase, err := s.passphrase(ctx, false)
+	if err != nil {
+		return err
+	}
+	key := pbkdf2([]byte(passphrase), stored.Salt, stored.Iterations, keySize)
+	plaintext, err := open(key, stored.Nonce, stored.Data)
+	if err != nil {
+		return fmt.Errorf("decrypting %s: wrong passphrase or corrupted file", s.path)
+	}
+
+	secrets := make(map[string]Secret)
+	if err := json.Unmarshal(plaintext, &secrets); err != nil {
+		return fmt.Errorf("parsing decrypted secrets: %w", err)
+	}
+
+	s.key, s.salt, s.iterations = key, stored.Salt, stored.Iterations
+	s.secrets = secrets
+	s.loaded = true
+	return nil
+}
+
+// create prepares an empty store under a new passphrase
+func (s *Store) create(ctx context.Context) error {
+	passphrase, err := s.passphrase(ctx, true)
+	if err != nil {
+		return err
+	}
+	if passphrase == "" {
+		return fmt.Errorf("the secrets passphrase must not be empty")
+	}
+	salt, err := randomSalt()
+	if err != nil {
+		return err
+	}
+
+	s.key = pbkdf2([]byte(passphrase), salt, keyIterations, keySize)
+	s.salt, s.iterations = salt, keyIterations
+	s.secrets = make(map[string]Secret)
+	s.loaded = true
+	return nil
+}
+
+// save encrypts the store and writes it with owner-only permissions
+func (s *Store) save() error {
+	plaintext, err := json.Marshal(s.secrets)
+	if err != nil {
+		return err
+	}
+	nonce, ciphertext, err := seal(s.key, plaintext)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(file{
+		Version:    1,
+		Salt:       s.salt,
+		Iterations: s.iterations,
+		Nonce:      nonce,
+		Data:       ciphertext,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return fmt.Errorf("creating secrets directory: %w", err)
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("writing secrets: %w", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("writing secrets: %w", err)
+	}
+	return nil
+}
+
+// Unlock decrypts the store, or creates it under a new passphrase if it
+// does not exist yet
+func (s *Store) Unlock(ctx context.Context) error {
+	return s.load(ctx)
+}
+
+// exists reports whether the store has been written yet. An unwritten store
+// is empty, and reading it needs no passphrase.
+func (s *Store) exists() bool {
+	_, err := os.Stat(s.path)
+	return !os.IsNotExist(err)
+}
+
+// Get returns the value of a secret
+func (s *Store) Get(ctx context.Context, name string) (string, error) {
+	if s.exists() {
+		if err := s.load(ctx); err != nil {
+			return "", err
+		}
+	}
+	secret, ok := s.secrets[name]
+	if !ok {
+		return "", fmt.Errorf("no secret named '%s' (add it with 'apicli secret set %s')", name, name)
+	}
+	return secret.Value, nil
+}
+
+// Set stores a secret, replacing any previous value. The first secret
+// stored creates the file under a new passphrase.
+func (s *Store) Set(ctx context.Context, name, value string) error {
+	if err := s.load(ctx); err != nil {
+		return err
+	}
+	s.secrets[name] = Secret{Value: value, UpdatedAt: time.Now()}
+	return s.save()
+}
+
+// List returns every secret
+func (s *Store) List(ctx context.Context) (map[string]Secret, error) {
+	if !s.exists() {
+		return map[string]Secret{}, nil
+	}
+	if err := s.load(ctx); err != nil {
+		return nil, err
+	}
+	return s.secrets, nil
+}
+
+// Delete removes the named secrets. Nothing is removed if any of them does
+// not exist; their names are returned instead.
+func (s *Store) Delete(ctx context.Context, names ...string) ([]string, error) {
+	if s.exists() {
+		if err := s.load(ctx); err != nil {
+			return nil, err
+		}
+	}
+	var missing []string
+	for _, name := range names {
+		if _, ok := s.secrets[name]; !ok {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return missing, nil
+	}
+	for _, name := range names {
+		delete(s.secrets, name)
+	}
+	return nil, s.save()
+}
@@ -0,0 +1,38 @@
+package secrets
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func passphrase(value string) Passphrase {
+	return func(context.Context, bool) (string, error) { return value, nil }
+}
+
+func TestStoreRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	if err := NewStore(dir, passphrase("right")).Set(context.Background(), "token", "s3cr3t"); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+
+	got, err := NewStore(dir, passphrase("right")).Get(context.Background(), "token")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if got != "s3cr3t" {
+		t.Errorf("Get = %q, want %q", got, "s3cr3t")
+	}
+}
+
+func TestStoreWrongPassphrase(t *testing.T) {
+	dir := t.TempDir()
+	if err := NewStore(dir, passphrase("right")).Set(context.Background(), "token", "s3cr3t"); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+
+	_, err := NewStore(dir, passphrase("wrong")).Get(context.Background(), "token")
+	if err == nil || !strings.Contains(err.Error(), "wrong passphrase") {
+		t.Errorf("Get with the wrong passphrase: err = %v, want a wrong passphrase error", err)
+	}
+}
@@ -0,0 +1,194 @@
+package template
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Position locates a template, or a placeholder within it, in a configuration file
+type Position struct {
+	File   string
+	Line   int
+	Column int
+}
+
+// String formats the position as file:line:col, or "" when unknown
+func (p Position) String() string {
+	if p.File == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s:%d:%d", p.File, p.Line, p.Column)
+}
+
+// Locator maps a byte offset within a template's source to its position in
+// the file the template came from
+type Locator func(offset int) Position
+
+// Template is a template string parsed once into literal text and
+// placeholder expressions, ready to be rendered any number of times
+type Template struct {
+	Source   string
+	segments []segment
+
+	// Precomputed rendering contexts, keyed by placeholder offset
+	jsonStrings map[int]bool
+	pathStart   int
+	queryStart  int
+}
+
+// segment is a literal run of text or a single placeholder
+type segment struct {
+	literal string
+	expr    *expression
+	text    string // placeholder body, for error messages
+	start   int    // offset of the placeholder in Source
+	pos     Position
+}
+
+// Reference is a variable a template needs in order to render
+type Reference struct {
+	Name string
+	Pos  Position
+}
+
+// compiled caches templates compiled without position information
+var compiled sync.Map
+
+// Compile parses a template string
+func Compile(source string) (*Template, error) {
+	return CompileAt(source, nil)
+}
+
+// CompileAt parses a template string whose placeholders can be located in
+// a file with locate. Errors, both now and when rendering, carry positions.
+func CompileAt(source string, locate Locator) (*Template, error) {
+	t := &Template{
+		Source:      source,
+		jsonStrings: jsonStringContexts(source),
+	}
+	t.pathStart, t.queryStart = urlParts(source)
+
+	last := 0
+	for _, ph := range findPlaceholders(source) {
+		var pos Position
+		if locate != nil {
+			pos = locate(ph.start)
+		}
+
+		expr, err := parseExpression(ph.expr)
+		if err != nil {
+			return nil, withPosition(pos, fmt.Errorf("parsing '${%s}': %w", ph.expr, err))
+		}
+		if err := expr.check(builtinFuncs); err != nil {
+			return nil, withPosition(pos, fmt.Errorf("in '${%s}': %w", ph.expr, err))
+		}
+
+		if ph.start > last {
+			t.segments = append(t.segments, segment{literal: source[last:ph.start]})
+		}
+		t.segments = append(t.segments, segment{expr: expr, text: ph.expr, start: ph.start, pos: pos})
+		last = ph.end
+	}
+	if last < len(source) {
+		t.segments = append(t.segments, segment{literal: source[last:]})
+	}
+
+	return t, nil
+}
+
+// compileCached compiles source once per process
+func compileCached(source string) (*Template, error) {
+	if t, ok := compiled.Load(source); ok {
+		return t.(*Template), nil
+	}
+	t, err := Compile(source)
+	if err != nil {
+		return nil, err
+	}
+	compiled.Store(source, t)
+	return t, nil
+}
+
+// References returns every variable the template references, including
+// optional ones and those passed to functions
+func (t *Template) References() []Reference {
+	var refs []Reference
+	for _, seg := range t.segments {
+		if seg.expr == nil {
+			continue
+		}
+		for _, name := range seg.expr.references(nil) {
+			refs = append(refs, Reference{Name: name, Pos: seg.pos})
+		}
+	}
+	return refs
+}
+
+// Required returns the variables without which the template cannot render:
+// references that are neither optional nor have a fallback
+func (t *Template) Required() []Reference {
+	var refs []Reference
+	for _, seg := range t.segments {
+		if seg.expr == nil {
+			continue
+		}
+		for _, name := range seg.expr.required(nil) {
+			refs = append(refs, Reference{Name: name, Pos: seg.pos})
+		}
+	}
+	return refs
+}
+
+// IsLoneExpression reports whether the template consists of exactly one placeholder
+func (t *Template) IsLoneExpression() bool {
+	return len(t.segments) == 1 && t.segments[0].expr != nil
+}
+
+// IsLoneReference reports whether the template is exactly one plain reference,
+// which structured bodies omit when it is unset
+func (t *Template) IsLoneReference() bool {
+	return t.IsLoneExpression() && t.segments[0].expr.kind == exprRef
+}
+
+// withPosition prefixes err with pos when the position is known
+func withPosition(pos Position, err error) error {
+	if pos.File == "" {
+		return err
+	}
+	return fmt.Errorf("%s: %w", pos, err)
+}
+
+// Set holds the compiled templates of an API, looked up by source text
+type Set struct {
+	templates []*Template
+	bySource  map[string]*Template
+}
+
+// NewSet creates an empty template set
+func NewSet() *Set {
+	return &Set{bySource: make(map[string]*Template)}
+}
+
+// Add adds a template; the first template added for a source wins lookups
+func (s *Set) Add(t *Template) {
+	s.templates = append(s.templates, t)
+	if _, ok := s.bySource[t.Source]; !ok {
+		s.bySource[t.Source] = t
+	}
+}
+
+// Lookup returns the compiled template for source, or nil
+func (s *Set) Lookup(source string) *Template {
+	if s == nil {
+		return nil
+	}
+	return s.bySource[source]
+}
+
+// Templates returns every template in the set, in the order added
+func (s *Set) Templates() []*Template {
+	if s == nil {
+		return nil
+	}
+	return s.templates
+}
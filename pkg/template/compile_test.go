@@ -0,0 +1,140 @@
+package template
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// lineLocator places a single-line template on line 3 of apis.yaml,
+// starting at column 10
+func lineLocator(offset int) Position {
+	return Position{File: "apis.yaml", Line: 3, Column: 10 + offset}
+}
+
+func TestCompileErrors(t *testing.T) {
+	tests := map[string]string{
+		"${}":                      "empty expression",
+		"${ }":                     "empty expression",
+		"${a b}":                   "unexpected 'b'",
+		"${a.}":                    "missing field name after '.'",
+		"${a[1}":                   "missing ']'",
+		"${a[x]}":                  "invalid index 'x'",
+		"${a[-1]}":                 "invalid index '-1'",
+		"${a[1 2]}":                "missing ']'",
+		"${a|}":                    "missing filter name after '|'",
+		"${base64(a b)}":           "unexpected 'b' in call to 'base64'",
+		"${base64(a}":              "missing ')' in call to 'base64'",
+		"${nope()}":                "unknown function 'nope'",
+		"${a|nope}":                "unknown filter 'nope'",
+		"${base64()}":              "function 'base64' takes 1 argument(s)",
+		"${now(1, 2)}":             "function 'now' takes 0 to 1 arguments",
+		"${a|randInt}":             "function 'randInt' cannot be used as a filter",
+		"${uuid()?}":               "only references can be optional",
+		`${"x":-y}`:                "only references can be optional",
+		"${base64(sha256(a, b))}":  "function 'sha256' takes 1 argument(s)",
+		"${1.2.3}":                 "invalid number '1.2.3'",
+		"${a} and ${b c}":          "unexpected 'c'",
+		"${a} and ${base64(1, 2)}": "function 'base64' takes 1 argument(s)",
+	}
+	for source, want := range tests {
+		_, err := Compile(source)
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("Compile(%q) error = %v, want it to contain %q", source, err, want)
+		}
+	}
+}
+
+func TestCompileErrorPositions(t *testing.T) {
+	_, err := CompileAt("/users/${id}/${bad(}", lineLocator)
+	if err == nil {
+		t.Fatal("CompileAt: want an error")
+	}
+	// The second placeholder starts at offset 13
+	if want := "apis.yaml:3:23: parsing '${bad(}'"; !strings.HasPrefix(err.Error(), want) {
+		t.Errorf("error = %q, want it to start with %q", err, want)
+	}
+
+	// Without a locator errors carry no position
+	_, err = Compile("${bad(}")
+	if err == nil || !strings.HasPrefix(err.Error(), "parsing '${bad(}'") {
+		t.Errorf("Compile error = %v, want no position", err)
+	}
+}
+
+func TestRenderErrorPositions(t *testing.T) {
+	source := "/users/${id}/${user.name.first}"
+	compiled, err := CompileAt(source, lineLocator)
+	if err != nil {
+		t.Fatalf("CompileAt: %v", err)
+	}
+	set := NewSet()
+	set.Add(compiled)
+
+	tests := []struct {
+		params map[string]interface{}
+		want   string
+	}{
+		{
+			map[string]interface{}{},
+			"apis.yaml:3:17: parameter 'id' not found",
+		},
+		{
+			map[string]interface{}{"id": 1, "user": map[string]interface{}{"name": "ann"}},
+			"apis.yaml:3:23: evaluating '${user.name.first}': 'user.name' is a string, not an object or array",
+		},
+	}
+	for _, tt := range tests {
+		r := NewRenderer(tt.params)
+		r.SetTemplates(set)
+		_, err := r.Render(source)
+		if err == nil || err.Error() != tt.want {
+			t.Errorf("Render with %v: error = %v, want %q", tt.params, err, tt.want)
+		}
+	}
+}
+
+func TestReferencesAndRequired(t *testing.T) {
+	compiled, err := CompileAt(`${base_url}/${id}/${q?}/${limit:-10}/${base64(sha256(token))}/${now("unix")}`, lineLocator)
+	if err != nil {
+		t.Fatalf("CompileAt: %v", err)
+	}
+
+	var refs, required []string
+	for _, ref := range compiled.References() {
+		refs = append(refs, ref.Name)
+	}
+	for _, ref := range compiled.Required() {
+		required = append(required, ref.Name)
+	}
+	if want := []string{"base_url", "id", "q", "limit", "token"}; !reflect.DeepEqual(refs, want) {
+		t.Errorf("References = %v, want %v", refs, want)
+	}
+	if want := []string{"base_url", "id", "token"}; !reflect.DeepEqual(required, want) {
+		t.Errorf("Required = %v, want %v", required, want)
+	}
+	if pos := compiled.Required()[1].Pos; pos.String() != "apis.yaml:3:22" {
+		t.Errorf("position of 'id' = %s, want apis.yaml:3:22", pos)
+	}
+}
+
+func TestFindPlaceholders(t *testing.T) {
+	tests := map[string][]string{
+		"plain text":              nil,
+		"${a}${b}":                {"a", "b"},
+		`${now("{x}")} tail`:      {`now("{x}")`},
+		`${base64("a\"}")}`:       {`base64("a\"}")`},
+		"${a} and ${unterminated": {"a"},
+		"$a {b} $ {c}":            nil,
+		"${outer${inner}}":        {"outer${inner"},
+	}
+	for source, want := range tests {
+		var got []string
+		for _, ph := range findPlaceholders(source) {
+			got = append(got, ph.expr)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("findPlaceholders(%q) = %q, want %q", source, got, want)
+		}
+	}
+}
@@ -0,0 +1,412 @@
+package template
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// placeholder is a ${...} occurrence in a template
+type placeholder struct {
+	start int    // offset of "${"
+	end   int    // offset just past "}"
+	expr  string // text between the braces
+}
+
+// findPlaceholders returns every ${...} in tmpl. Closing braces inside
+// double-quoted function arguments do not end a placeholder, and an
+// unterminated "${" is left as literal text.
+func findPlaceholders(tmpl string) []placeholder {
+	var found []placeholder
+	for i := 0; i < len(tmpl); i++ {
+		if !strings.HasPrefix(tmpl[i:], "${") {
+			continue
+		}
+
+		end := -1
+		inQuote, escaped := false, false
+		for j := i + 2; j < len(tmpl) && end < 0; j++ {
+			switch {
+			case escaped:
+				escaped = false
+			case inQuote && tmpl[j] == '\\':
+				escaped = true
+			case tmpl[j] == '"':
+				inQuote = !inQuote
+			case !inQuote && tmpl[j] == '}':
+				end = j
+			}
+		}
+		if end < 0 {
+			break
+		}
+
+		found = append(found, placeholder{start: i, end: end + 1, expr: tmpl[i+2 : end]})
+		i = end
+	}
+	return found
+}
+
+// ReplacePlaceholders replaces every ${...} in tmpl with the result of
+// replace, which receives the text between the braces
+func ReplacePlaceholders(tmpl string, replace func(expr string) string) string {
+	var out strings.Builder
+	last := 0
+	for _, ph := range findPlaceholders(tmpl) {
+		out.WriteString(tmpl[last:ph.start])
+		out.WriteString(replace(ph.expr))
+		last = ph.end
+	}
+	out.WriteString(tmpl[last:])
+	return out.String()
+}
+
+// exprKind distinguishes the forms an expression can take
+type exprKind int
+
+const (
+	exprRef exprKind = iota
+	exprCall
+	exprString
+	exprNumber
+)
+
+// expression is a parsed placeholder body:
+//
+//	name            parameter or variable reference
+//	name.key[0]     field and element access into object and array values
+//	name?           optional reference, renders empty when unset
+//	name:-fallback  reference with a literal fallback
+//	fn(arg, ...)    function call; arguments are references, calls,
+//	                "strings" or numbers
+//	value|fn        filter: passes the value to a one-argument function
+type expression struct {
+	kind     exprKind
+	name     string // reference or function name
+	ref      string // reference as written, including its path
+	path     []pathStep
+	literal  interface{}
+	args     []*expression
+	filters  []string
+	optional bool
+	fallback *string
+}
+
+// pathStep is a field (.key or ["key"]) or element ([0]) access
+type pathStep struct {
+	key     string
+	index   int
+	isIndex bool
+}
+
+// parseExpression parses the text between "${" and "}"
+func parseExpression(text string) (*expression, error) {
+	p := &exprParser{text: text}
+	p.skipSpace()
+	if p.done() {
+		return nil, fmt.Errorf("empty expression")
+	}
+
+	expr, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		p.skipSpace()
+		if !strings.HasPrefix(p.rest(), "|") {
+			break
+		}
+		p.pos++
+		p.skipSpace()
+		filter := p.parseIdent()
+		if filter == "" {
+			return nil, fmt.Errorf("missing filter name after '|' in expression '%s'", text)
+		}
+		expr.filters = append(expr.filters, filter)
+	}
+
+	p.skipSpace()
+	switch {
+	case strings.HasPrefix(p.rest(), ":-"):
+		fallback := p.rest()[2:]
+		expr.fallback = &fallback
+		p.pos = len(p.text)
+	case strings.HasPrefix(p.rest(), "?"):
+		expr.optional = true
+		p.pos++
+	}
+
+	p.skipSpace()
+	if !p.done() {
+		return nil, fmt.Errorf("unexpected '%s' in expression '%s'", p.rest(), text)
+	}
+	if expr.kind != exprRef && (expr.optional || expr.fallback != nil) {
+		return nil, fmt.Errorf("only references can be optional or have a fallback in '%s'", text)
+	}
+	return expr, nil
+}
+
+// exprParser is a small recursive-descent parser over a placeholder body
+type exprParser struct {
+	text string
+	pos  int
+}
+
+func (p *exprParser) done() bool {
+	return p.pos >= len(p.text)
+}
+
+func (p *exprParser) rest() string {
+	return p.text[p.pos:]
+}
+
+func (p *exprParser) skipSpace() {
+	for !p.done() && (p.text[p.pos] == ' ' || p.text[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+// parseOperand parses a reference, call, string or number
+func (p *exprParser) parseOperand() (*expression, error) {
+	p.skipSpace()
+	if p.done() {
+		return nil, fmt.Errorf("unexpected end of expression '%s'", p.text)
+	}
+
+	c := p.text[p.pos]
+	switch {
+	case c == '"':
+		return p.parseString()
+	case c == '-' || (c >= '0' && c <= '9'):
+		return p.parseNumber()
+	case isIdentChar(c):
+		start := p.pos
+		name := p.parseIdent()
+		p.skipSpace()
+		if !p.done() && p.text[p.pos] == '(' {
+			return p.parseCall(name)
+		}
+		p.pos = start + len(name)
+
+		ref := &expression{kind: exprRef, name: name}
+		if err := p.parsePath(ref); err != nil {
+			return nil, err
+		}
+		ref.ref = p.text[start:p.pos]
+		return ref, nil
+	}
+
+	return nil, fmt.Errorf("unexpected '%c' in expression '%s'", c, p.text)
+}
+
+func (p *exprParser) parseIdent() string {
+	start := p.pos
+	for !p.done() && isIdentChar(p.text[p.pos]) {
+		p.pos++
+	}
+	return p.text[start:p.pos]
+}
+
+// parsePath parses the field and element accesses following a reference
+func (p *exprParser) parsePath(ref *expression) error {
+	for !p.done() {
+		switch p.text[p.pos] {
+		case '.':
+			p.pos++
+			key := p.parseIdent()
+			if key == "" {
+				return fmt.Errorf("missing field name after '.' in expression '%s'", p.text)
+			}
+			ref.path = append(ref.path, pathStep{key: key})
+
+		case '[':
+			p.pos++
+			p.skipSpace()
+			if p.done() {
+				return fmt.Errorf("missing ']' in expression '%s'", p.text)
+			}
+			if p.text[p.pos] == '"' {
+				key, err := p.parseString()
+				if err != nil {
+					return err
+				}
+				ref.path = append(ref.path, pathStep{key: key.literal.(string)})
+			} else {
+				start := p.pos
+				for !p.done() && p.text[p.pos] != ']' && p.text[p.pos] != ' ' && p.text[p.pos] != '\t' {
+					p.pos++
+				}
+				index, err := strconv.Atoi(p.text[start:p.pos])
+				if err != nil || index < 0 {
+					return fmt.Errorf("invalid index '%s' in expression '%s'", p.text[start:p.pos], p.text)
+				}
+				ref.path = append(ref.path, pathStep{index: index, isIndex: true})
+			}
+			p.skipSpace()
+			if p.done() || p.text[p.pos] != ']' {
+				return fmt.Errorf("missing ']' in expression '%s'", p.text)
+			}
+			p.pos++
+
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+func (p *exprParser) parseCall(name string) (*expression, error) {
+	call := &expression{kind: exprCall, name: name}
+	p.pos++ // "("
+
+	p.skipSpace()
+	if !p.done() && p.text[p.pos] == ')' {
+		p.pos++
+		return call, nil
+	}
+
+	for {
+		arg, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		call.args = append(call.args, arg)
+
+		p.skipSpace()
+		if p.done() {
+			return nil, fmt.Errorf("missing ')' in call to '%s'", name)
+		}
+		switch p.text[p.pos] {
+		case ',':
+			p.pos++
+		case ')':
+			p.pos++
+			return call, nil
+		default:
+			return nil, fmt.Errorf("unexpected '%c' in call to '%s'", p.text[p.pos], name)
+		}
+	}
+}
+
+func (p *exprParser) parseString() (*expression, error) {
+	start := p.pos
+	p.pos++
+	for !p.done() {
+		switch p.text[p.pos] {
+		case '\\':
+			p.pos += 2
+			continue
+		case '"':
+			p.pos++
+			value, err := strconv.Unquote(p.text[start:p.pos])
+			if err != nil {
+				return nil, fmt.Errorf("invalid string %s: %w", p.text[start:p.pos], err)
+			}
+			return &expression{kind: exprString, literal: value}, nil
+		}
+		p.pos++
+	}
+	return nil, fmt.Errorf("unterminated string in expression '%s'", p.text)
+}
+
+func (p *exprParser) parseNumber() (*expression, error) {
+	start := p.pos
+	if p.text[p.pos] == '-' {
+		p.pos++
+	}
+	for !p.done() && (p.text[p.pos] == '.' || (p.text[p.pos] >= '0' && p.text[p.pos] <= '9')) {
+		p.pos++
+	}
+
+	raw := p.text[start:p.pos]
+	if n, err := strconv.Atoi(raw); err == nil {
+		return &expression{kind: exprNumber, literal: n}, nil
+	}
+	f, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid number '%s'", raw)
+	}
+	return &expression{kind: exprNumber, literal: f}, nil
+}
+
+func isIdentChar(c byte) bool {
+	return c == '_' || c == '-' ||
+		(c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+// references appends the names of all references in the expression to refs
+func (e *expression) references(refs []string) []string {
+	switch e.kind {
+	case exprRef:
+		refs = append(refs, e.name)
+	case exprCall:
+		for _, arg := range e.args {
+			refs = arg.references(refs)
+		}
+	}
+	return refs
+}
+
+// required appends the names of references that must resolve for the
+// expression to evaluate
+func (e *expression) required(refs []string) []string {
+	switch e.kind {
+	case exprRef:
+		if !e.optional && e.fallback == nil {
+			refs = append(refs, e.name)
+		}
+	case exprCall:
+		for _, arg := range e.args {
+			refs = arg.required(refs)
+		}
+	}
+	return refs
+}
+
+// sensitive reports whether the expression calls a sensitive function, so
+// its value, and everything computed from it, must be masked
+func (e *expression) sensitive() bool {
+	if e.kind != exprCall {
+		return false
+	}
+	if builtinFuncs[e.name].Sensitive {
+		return true
+	}
+	for _, arg := range e.args {
+		if arg.sensitive() {
+			return true
+		}
+	}
+	return false
+}
+
+// check verifies that every function called by the expression exists and
+// is given a valid number of arguments
+func (e *expression) check(funcs map[string]Func) error {
+	for _, filter := range e.filters {
+		fn, ok := funcs[filter]
+		if !ok {
+			return fmt.Errorf("unknown filter '%s'", filter)
+		}
+		if fn.MinArgs > 1 || (fn.MaxArgs >= 0 && fn.MaxArgs < 1) {
+			return fmt.Errorf("function '%s' cannot be used as a filter: it %s", filter, fn.arity())
+		}
+	}
+	if e.kind != exprCall {
+		return nil
+	}
+	fn, ok := funcs[e.name]
+	if !ok {
+		return fmt.Errorf("unknown function '%s'", e.name)
+	}
+	if len(e.args) < fn.MinArgs || (fn.MaxArgs >= 0 && len(e.args) > fn.MaxArgs) {
+		return fmt.Errorf("function '%s' %s", e.name, fn.arity())
+	}
+	for _, arg := range e.args {
+		if err := arg.check(funcs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package template
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Func is a function callable from templates as ${name(arg, ...)}
+type Func struct {
+	MinArgs int
+	MaxArgs int // negative for no limit
+	// Call is nil for functions each renderer provides with SetFunc
+	Call func(args []interface{}) (interface{}, error)
+
+	// Sensitive results are passed to the renderer's masker, so they are
+	// hidden in output and history
+	Sensitive bool
+}
+
+func (f Func) arity() string {
+	switch {
+	case f.MinArgs == f.MaxArgs:
+		return fmt.Sprintf("takes %d argument(s)", f.MinArgs)
+	case f.MaxArgs < 0:
+		return fmt.Sprintf("takes at least %d argument(s)", f.MinArgs)
+	default:
+		return fmt.Sprintf("takes %d to %d arguments", f.MinArgs, f.MaxArgs)
+	}
+}
+
+// urlEncoded is text urlencode() has already escaped, which RenderURL
+// inserts without escaping it again
+type urlEncoded string
+
+// timeLayouts maps the layout names accepted by now() to Go layouts
+var timeLayouts = map[string]string{
+	"RFC3339":     time.RFC3339,
+	"RFC3339Nano": time.RFC3339Nano,
+	"RFC1123":     time.RFC1123,
+	"RFC1123Z":    time.RFC1123Z,
+	"RFC822":      time.RFC822,
+	"Kitchen":     time.Kitchen,
+	"date":        "2006-01-02",
+	"datetime":    "2006-01-02 15:04:05",
+}
+
+// builtinFuncs are the functions available to every template
+var builtinFuncs = map[string]Func{
+	"uuid": {MinArgs: 0, MaxArgs: 0, Call: func(args []interface{}) (interface{}, error) {
+		return uuid.New().String(), nil
+	}},
+	"now": {MinArgs: 0, MaxArgs: 1, Call: func(args []interface{}) (interface{}, error) {
+		now := time.Now()
+		if len(args) == 0 {
+			return now.Format(time.RFC3339), nil
+		}
+		layout := fmt.Sprint(args[0])
+		switch layout {
+		case "unix":
+			return now.Unix(), nil
+		case "unixmilli":
+			return now.UnixMilli(), nil
+		}
+		if named, ok := timeLayouts[layout]; ok {
+			layout = named
+		}
+		return now.Format(layout), nil
+	}},
+	// Environment variables often hold tokens, so whatever is read is masked
+	"env": {MinArgs: 1, MaxArgs: 1, Sensitive: true, Call: func(args []interface{}) (interface{}, error) {
+		name := fmt.Sprint(args[0])
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			return nil, fmt.Errorf("environment variable '%s' is not set", name)
+		}
+		return value, nil
+	}},
+	"base64": {MinArgs: 1, MaxArgs: 1, Call: func(args []interface{}) (interface{}, error) {
+		return base64.StdEncoding.EncodeToString([]byte(fmt.Sprint(args[0]))), nil
+	}},
+	"urlencode": {MinArgs: 1, MaxArgs: 1, Call: func(args []interface{}) (interface{}, error) {
+		return urlEncoded(url.QueryEscape(fmt.Sprint(args[0]))), nil
+	}},
+	"json": {MinArgs: 1, MaxArgs: 1, Call: func(args []interface{}) (interface{}, error) {
+		encoded, err := marshalJSON(args[0])
+		if err != nil {
+			return nil, err
+		}
+		return json.RawMessage(encoded), nil
+	}},
+	"sha256": {MinArgs: 1, MaxArgs: 1, Call: func(args []interface{}) (interface{}, error) {
+		sum := sha256.Sum256([]byte(fmt.Sprint(args[0])))
+		return hex.EncodeToString(sum[:]), nil
+	}},
+	// secret reads the secrets store, which belongs to the command, so the
+	// command gives each renderer its implementation
+	"secret": {MinArgs: 1, MaxArgs: 1, Sensitive: true},
+	"randInt": {MinArgs: 2, MaxArgs: 2, Call: func(args []interface{}) (interface{}, error) {
+		min, ok1 := args[0].(int)
+		max, ok2 := args[1].(int)
+		if !ok1 || !ok2 {
+			return nil, fmt.Errorf("arguments must be integers")
+		}
+		if min > max {
+			return nil, fmt.Errorf("min %d is greater than max %d", min, max)
+		}
+		return min + rand.Intn(max-min+1), nil
+	}},
+}
@@ -1,17 +1,33 @@
 package template
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
-	"regexp"
+	"net/url"
 	"strconv"
 	"strings"
 )
 
 // Renderer handles template variable substitution
 type Renderer struct {
-params map[string]interface{}
+params    map[string]interface{}
+variables map[string]string
+captured  map[string]interface{}
+credentials map[string]string
+templates *Set
+mask      func(value string)
+funcs     map[string]func(args []interface{}) (interface{}, error)
 }
 
+// CapturedVariables is the name under which templates reach values captured
+// from earlier responses, as ${vars.NAME}
+const CapturedVariables = "vars"
+
+// HelperCredentials is the name under which templates reach the values a
+// module's credential helper returned, as ${credentials.NAME}
+const HelperCredentials = "credentials"
+
 // GetParams returns the current parameter map
 func (r *Renderer) GetParams() map[string]interface{} {
 return r.params
@@ -24,38 +40,445 @@ func NewRenderer(params map[string]interface{}) *Renderer {
 	}
 }
 
+// SetVariables sets fallback variables (e.g. from the selected environment)
+// that are used when a template references a name that is not a parameter
+func (r *Renderer) SetVariables(variables map[string]string) {
+	r.variables = variables
+}
+
+// SetCaptured sets the values captured from earlier responses
+func (r *Renderer) SetCaptured(values map[string]interface{}) {
+	r.captured = values
+}
+
+// SetCredentials sets the values returned by the module's credential helper
+func (r *Renderer) SetCredentials(values map[string]string) {
+	r.credentials = values
+}
+
+// SetMasker sets the function told about every value a sensitive function
+// such as secret() returns
+func (r *Renderer) SetMasker(mask func(value string)) {
+	r.mask = mask
+}
+
+// SetFunc provides the implementation of a template function that needs
+// state of the command using the renderer, such as secret()
+func (r *Renderer) SetFunc(name string, call func(args []interface{}) (interface{}, error)) {
+	if r.funcs == nil {
+		r.funcs = make(map[string]func(args []interface{}) (interface{}, error))
+	}
+	r.funcs[name] = call
+}
+
+// SetTemplates provides the precompiled templates of the API being rendered,
+// so rendering skips parsing and errors report file positions
+func (r *Renderer) SetTemplates(templates *Set) {
+	r.templates = templates
+}
+
+// compile returns the compiled form of a template string
+func (r *Renderer) compile(tmpl string) (*Template, error) {
+	if t := r.templates.Lookup(tmpl); t != nil {
+		return t, nil
+	}
+	return compileCached(tmpl)
+}
+
+// lookup resolves a template variable, preferring parameters over variables
+func (r *Renderer) lookup(name string) (interface{}, bool) {
+	if value, ok := r.params[name]; ok {
+		return value, true
+	}
+	if value, ok := r.variables[name]; ok {
+		return value, true
+	}
+	if name == CapturedVariables {
+		if r.captured == nil {
+			return map[string]interface{}{}, true
+		}
+		return r.captured, true
+	}
+	if name == HelperCredentials && r.credentials != nil {
+		values := make(map[string]interface{}, len(r.credentials))
+		for k, v := range r.credentials {
+			values[k] = v
+		}
+		return values, true
+	}
+	return nil, false
+}
+
 // Render substitutes template variables in the given string with their corresponding values
 func (r *Renderer) Render(tmpl string) (string, error) {
+	t, err := r.compile(tmpl)
+	if err != nil {
+		return "", err
+	}
+	return r.render(t, func(_ int, value interface{}) (string, error) {
+		return r.convertToString(value)
+	})
+}
+
+// Value returns the native value of a template that is exactly one
+// placeholder. The boolean is false for other templates and for an
+// optional reference that is unset.
+func (r *Renderer) Value(tmpl string) (interface{}, bool, error) {
+	t, err := r.compile(tmpl)
+	if err != nil || !t.IsLoneExpression() {
+		return nil, false, err
+	}
+	seg := t.segments[0]
+	value, ok, err := r.evaluate(seg.expr)
+	if err != nil {
+		return nil, false, withPosition(seg.pos, fmt.Errorf("evaluating '${%s}': %w", seg.text, err))
+	}
+	return value, ok, nil
+}
+
+// RenderJSON renders a JSON document template. Variables inside JSON string
+// literals are JSON-escaped; variables outside of strings are emitted as
+// native JSON values, so integers and booleans stay unquoted and strings
+// are quoted. There a fallback that is a number, true, false or null keeps
+// that type, and an unset optional reference is null.
+func (r *Renderer) RenderJSON(tmpl string) (string, error) {
+	t, err := r.compile(tmpl)
+	if err != nil {
+		return "", err
+	}
+	return r.render(t, func(start int, value interface{}) (string, error) {
+		if !t.jsonStrings[start] {
+			return marshalJSON(value)
+		}
+		str, err := r.convertToString(value)
+		if err != nil {
+			return "", err
+		}
+		quoted, err := marshalJSON(str)
+		if err != nil {
+			return "", err
+		}
+		return quoted[1 : len(quoted)-1], nil
+	})
+}
+
+// RenderURL renders a URL template, percent-escaping variables in the path
+// and query. Variables in the scheme and host part (such as a leading
+// ${base_url}) and the results of urlencode() are inserted unchanged.
+func (r *Renderer) RenderURL(tmpl string) (string, error) {
+	t, err := r.compile(tmpl)
+	if err != nil {
+		return "", err
+	}
+	return r.render(t, func(start int, value interface{}) (string, error) {
+		if encoded, ok := value.(urlEncoded); ok {
+			return string(encoded), nil
+		}
+		str, err := r.convertToString(value)
+		if err != nil {
+			return "", err
+		}
+		switch {
+		case start < t.pathStart:
+			return str, nil
+		case start < t.queryStart:
+			return url.PathEscape(str), nil
+		default:
+			return url.QueryEscape(str), nil
+		}
+	})
+}
+
+// render replaces every variable in a compiled template with the result of
+// format, which receives the variable's offset in the source and its value
+func (r *Renderer) render(t *Template, format func(start int, value interface{}) (string, error)) (string, error) {
 	// If template contains no variables, return as is
-	if !strings.Contains(tmpl, "${") {
-		return tmpl, nil
+	if len(t.segments) == 1 && t.segments[0].expr == nil {
+		return t.Source, nil
 	}
 
-	// Find all variables using regex
-	re := regexp.MustCompile(`\${([^}]+)}`)
-	result := tmpl
+	var result strings.Builder
+	for _, seg := range t.segments {
+		if seg.expr == nil {
+			result.WriteString(seg.literal)
+			continue
+		}
+
+		value, err := r.evaluateSegment(seg)
+		if err != nil {
+			return "", err
+		}
+
+		// Convert value to string based on type and context
+		strValue, err := format(seg.start, value)
+		if err != nil {
+			return "", withPosition(seg.pos, fmt.Errorf("converting value for '${%s}': %w", seg.text, err))
+		}
+		result.WriteString(strValue)
+	}
 
-	// Replace all variables
-	matches := re.FindAllStringSubmatch(tmpl, -1)
-	for _, match := range matches {
-		fullMatch := match[0] // ${var}
-		varName := match[1]   // var
+	return result.String(), nil
+}
 
-		value, ok := r.params[varName]
-		if !ok {
-			return "", fmt.Errorf("parameter '%s' not found", varName)
+// evaluateSegment evaluates a placeholder. A reference that resolves to
+// nothing is an error unless it is optional, when its value is unset.
+func (r *Renderer) evaluateSegment(seg segment) (interface{}, error) {
+	value, ok, err := r.evaluate(seg.expr)
+	if err != nil {
+		return nil, withPosition(seg.pos, fmt.Errorf("evaluating '${%s}': %w", seg.text, err))
+	}
+	if !ok {
+		if seg.expr.optional {
+			return unset{}, nil
 		}
+		if seg.expr.name == CapturedVariables && len(seg.expr.path) > 0 {
+			return nil, withPosition(seg.pos, fmt.Errorf("variable '%s' has not been captured or has expired", strings.TrimPrefix(seg.expr.ref, CapturedVariables+".")))
+		}
+		if seg.expr.name == HelperCredentials && len(seg.expr.path) > 0 {
+			name := strings.TrimPrefix(seg.expr.ref, HelperCredentials+".")
+			if r.credentials == nil {
+				return nil, withPosition(seg.pos, fmt.Errorf("credential '%s' is not available: no credential_helper is configured for this module", name))
+			}
+			return nil, withPosition(seg.pos, fmt.Errorf("credential '%s' was not returned by the credential helper", name))
+		}
+		return nil, withPosition(seg.pos, fmt.Errorf("parameter '%s' not found", seg.expr.ref))
+	}
+	return value, nil
+}
 
-		// Convert value to string based on type
-		strValue, err := r.convertToString(value)
+// evaluate computes the value of an expression, including its filters. The
+// boolean is false when a reference without a fallback resolves to nothing.
+func (r *Renderer) evaluate(e *expression) (interface{}, bool, error) {
+	value, ok, err := r.evaluateOperand(e)
+	if err != nil || !ok {
+		return value, ok, err
+	}
+	for _, filter := range e.filters {
+		value, err = r.call(filter, []interface{}{value}, e.sensitive())
 		if err != nil {
-			return "", fmt.Errorf("converting value for parameter '%s': %w", varName, err)
+			return nil, false, fmt.Errorf("%s: %w", filter, err)
+		}
+	}
+	return value, true, nil
+}
+
+// evaluateOperand computes the value of an expression before its filters
+func (r *Renderer) evaluateOperand(e *expression) (interface{}, bool, error) {
+	switch e.kind {
+	case exprString, exprNumber:
+		return e.literal, true, nil
+
+	case exprRef:
+		if value, ok := r.lookup(e.name); ok {
+			value, ok, err := walkPath(e.name, value, e.path)
+			if err != nil {
+				return nil, false, err
+			}
+			if ok {
+				return value, true, nil
+			}
+		}
+		if e.fallback != nil {
+			return fallbackText(*e.fallback), true, nil
 		}
+		return nil, false, nil
 
-		result = strings.Replace(result, fullMatch, strValue, -1)
+	case exprCall:
+		args := make([]interface{}, len(e.args))
+		for i, arg := range e.args {
+			value, ok, err := r.evaluate(arg)
+			if err != nil {
+				return nil, false, err
+			}
+			if !ok {
+				return nil, false, fmt.Errorf("%s: parameter '%s' not found", e.name, arg.ref)
+			}
+			args[i] = value
+		}
+
+		value, err := r.call(e.name, args, e.sensitive())
+		if err != nil {
+			return nil, false, fmt.Errorf("%s: %w", e.name, err)
+		}
+		return value, true, nil
+	}
+
+	return nil, false, fmt.Errorf("unsupported expression")
+}
+
+// call runs a template function. Results of sensitive functions, and of
+// functions applied to them, are reported to the masker.
+func (r *Renderer) call(name string, args []interface{}, sensitive bool) (interface{}, error) {
+	fn := builtinFuncs[name]
+	call := fn.Call
+	if own, ok := r.funcs[name]; ok {
+		call = own
+	}
+	if call == nil {
+		return nil, fmt.Errorf("not available in this command")
+	}
+	value, err := call(args)
+	if err != nil {
+		return nil, err
+	}
+	if (sensitive || fn.Sensitive) && r.mask != nil {
+		r.mask(FormatValue(value))
+	}
+	return value, nil
+}
+
+// walkPath follows field and element accesses into a value. A missing
+// field or an index out of range resolves to nothing, while accessing into
+// a value of the wrong kind is an error.
+func walkPath(name string, value interface{}, path []pathStep) (interface{}, bool, error) {
+	for _, step := range path {
+		if raw, ok := value.(json.RawMessage); ok {
+			var decoded interface{}
+			if err := decodeJSON(string(raw), &decoded); err != nil {
+				return nil, false, fmt.Errorf("'%s' is not valid JSON: %w", name, err)
+			}
+			value = decoded
+		}
+		switch v := value.(type) {
+		case map[string]interface{}:
+			if step.isIndex {
+				return nil, false, fmt.Errorf("'%s' is an object, not an array", name)
+			}
+			field, ok := v[step.key]
+			if !ok {
+				return nil, false, nil
+			}
+			value = field
+			name += "." + step.key
+		case []interface{}:
+			if !step.isIndex {
+				return nil, false, fmt.Errorf("'%s' is an array, not an object", name)
+			}
+			if step.index >= len(v) {
+				return nil, false, nil
+			}
+			value = v[step.index]
+			name += fmt.Sprintf("[%d]", step.index)
+		default:
+			return nil, false, fmt.Errorf("'%s' is a %s, not an object or array", name, kindName(value))
+		}
+	}
+	return value, true, nil
+}
+
+// kindName names the JSON kind of a value for error messages
+func kindName(value interface{}) string {
+	switch value.(type) {
+	case string, urlEncoded, fallbackText:
+		return "string"
+	case bool:
+		return "boolean"
+	case nil:
+		return "null"
+	default:
+		return "number"
+	}
+}
+
+// jsonStringContexts reports, for the offset of each variable in a JSON
+// template, whether the variable appears inside a JSON string literal
+func jsonStringContexts(tmpl string) map[int]bool {
+	contexts := make(map[int]bool)
+	placeholders := findPlaceholders(tmpl)
+
+	inString, escaped := false, false
+	next := 0
+	for i := 0; i < len(tmpl); i++ {
+		// Skip over variables so quotes inside them don't count
+		if next < len(placeholders) && i == placeholders[next].start {
+			contexts[i] = inString
+			i = placeholders[next].end - 1
+			next++
+			continue
+		}
+
+		switch {
+		case escaped:
+			escaped = false
+		case inString && tmpl[i] == '\\':
+			escaped = true
+		case tmpl[i] == '"':
+			inString = !inString
+		}
 	}
 
-	return result, nil
+	return contexts
+}
+
+// urlParts returns the offsets where the path and the query of a URL
+// template start
+func urlParts(tmpl string) (pathStart, queryStart int) {
+	queryStart = len(tmpl)
+	if i := strings.Index(tmpl, "?"); i >= 0 {
+		queryStart = i
+	}
+
+	switch {
+	case strings.Contains(tmpl[:queryStart], "://"):
+		authority := strings.Index(tmpl, "://") + 3
+		pathStart = queryStart
+		if i := strings.Index(tmpl[authority:queryStart], "/"); i >= 0 {
+			pathStart = authority + i
+		}
+	case strings.HasPrefix(tmpl, "${"):
+		// A leading variable supplies the scheme and host
+		if placeholders := findPlaceholders(tmpl); len(placeholders) > 0 {
+			pathStart = placeholders[0].end
+		}
+	}
+
+	return pathStart, queryStart
+}
+
+// fallbackText is the literal fallback of a reference (${name:-text}). It is
+// text, except as a JSON value of its own, where a number, true, false or
+// null stands for itself.
+type fallbackText string
+
+func (f fallbackText) MarshalJSON() ([]byte, error) {
+	var value interface{}
+	if err := decodeJSON(string(f), &value); err == nil {
+		switch value.(type) {
+		case json.Number, bool, nil:
+			return []byte(strings.TrimSpace(string(f))), nil
+		}
+	}
+	encoded, err := marshalJSON(string(f))
+	return []byte(encoded), err
+}
+
+// unset is the value of an optional reference that resolves to nothing:
+// empty text, or null as a JSON value of its own
+type unset struct{}
+
+func (unset) MarshalJSON() ([]byte, error) {
+	return []byte("null"), nil
+}
+
+// marshalJSON encodes a value as JSON without escaping HTML characters
+func marshalJSON(value interface{}) (string, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(value); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(buf.String(), "\n"), nil
+}
+
+// FormatValue formats a parameter value as text, the way it renders in
+// plain templates
+func FormatValue(value interface{}) string {
+	str, err := (&Renderer{}).convertToString(value)
+	if err != nil {
+		return fmt.Sprintf("%v", value)
+	}
+	return str
 }
 
 // convertToString converts an interface{} value to a string
@@ -63,15 +486,30 @@ func (r *Renderer) convertToString(value interface{}) (string, error) {
 	switch v := value.(type) {
 	case string:
 		return v, nil
+	case urlEncoded:
+		return string(v), nil
+	case fallbackText:
+		return string(v), nil
+	case unset:
+		return "", nil
 	case int:
 		return strconv.Itoa(v), nil
 	case bool:
 		return strconv.FormatBool(v), nil
 	case float64:
 		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case json.Number:
+		return v.String(), nil
+	case json.RawMessage:
+		return string(v), nil
 	case []interface{}:
+		// Arrays of scalars join with commas, as query strings expect
 		parts := make([]string, len(v))
 		for i, item := range v {
+			switch item.(type) {
+			case []interface{}, map[string]interface{}:
+				return marshalJSON(v)
+			}
 			str, err := r.convertToString(item)
 			if err != nil {
 				return "", err
@@ -80,39 +518,28 @@ func (r *Renderer) convertToString(value interface{}) (string, error) {
 		}
 		return strings.Join(parts, ","), nil
 	case map[string]interface{}:
-		pairs := make([]string, 0, len(v))
-		for key, val := range v {
-			str, err := r.convertToString(val)
-			if err != nil {
-				return "", err
-			}
-			pairs = append(pairs, fmt.Sprintf("%s:%s", key, str))
-		}
-		return strings.Join(pairs, ","), nil
+		return marshalJSON(v)
 	default:
 		return fmt.Sprintf("%v", v), nil
 	}
 }
 
-// ValidateType validates if a value matches the expected type
-func ValidateType(value string, typeName string) error {
-	switch {
-	case strings.HasPrefix(typeName, "array["):
-		if !strings.HasPrefix(value, "[") || !strings.HasSuffix(value, "]") {
-			return fmt.Errorf("value must be an array")
-		}
-	case typeName == "integer":
-		if _, err := strconv.Atoi(value); err != nil {
-			return fmt.Errorf("value must be an integer")
-		}
-	case typeName == "boolean":
-		if value != "true" && value != "false" {
-			return fmt.Errorf("value must be a boolean")
-		}
-	case typeName == "number":
-		if _, err := strconv.ParseFloat(value, 64); err != nil {
-			return fmt.Errorf("value must be a number")
-		}
+// References returns the names of the variables referenced by a template,
+// including those passed to functions, in order of appearance
+func References(tmpl string) []string {
+	t, err := compileCached(tmpl)
+	if err != nil {
+		return nil
+	}
+	var refs []string
+	for _, ref := range t.References() {
+		refs = append(refs, ref.Name)
 	}
-	return nil
+	return refs
+}
+
+// Check reports syntax errors and calls to unknown functions in a template
+func Check(tmpl string) error {
+	_, err := compileCached(tmpl)
+	return err
 }
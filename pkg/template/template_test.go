@@ -0,0 +1,325 @@
+package template
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestRenderURLKeepsURLEncodeResults(t *testing.T) {
+	r := NewRenderer(map[string]interface{}{"path": "a/b", "q": "x&y=z"})
+	tests := map[string]string{
+		"https://api.test/files/${urlencode(path)}": "https://api.test/files/a%2Fb",
+		"https://api.test/files/${path|urlencode}":  "https://api.test/files/a%2Fb",
+		"https://api.test/search?q=${urlencode(q)}": "https://api.test/search?q=x%26y%3Dz",
+		// Other values are still escaped where they appear
+		"https://api.test/files/${path}": "https://api.test/files/a%2Fb",
+		"https://api.test/search?q=${q}": "https://api.test/search?q=x%26y%3Dz",
+	}
+	for tmpl, want := range tests {
+		got, err := r.RenderURL(tmpl)
+		if err != nil {
+			t.Errorf("RenderURL(%q): %v", tmpl, err)
+			continue
+		}
+		if got != want {
+			t.Errorf("RenderURL(%q) = %q, want %q", tmpl, got, want)
+		}
+	}
+}
+
+func TestRenderJSONFallbacksAndOptionals(t *testing.T) {
+	r := NewRenderer(map[string]interface{}{})
+	tests := map[string]string{
+		`{"n": ${x:-5}}`:     `{"n": 5}`,
+		`{"n": ${x:-5.5}}`:   `{"n": 5.5}`,
+		`{"b": ${x:-true}}`:  `{"b": true}`,
+		`{"v": ${x:-null}}`:  `{"v": null}`,
+		`{"s": ${x:-abc}}`:   `{"s": "abc"}`,
+		`{"s": "${x:-5}"}`:   `{"s": "5"}`,
+		`{"o": ${x?}}`:       `{"o": null}`,
+		`{"o": "${x?}"}`:     `{"o": ""}`,
+		`{"o": "a-${x?}-b"}`: `{"o": "a--b"}`,
+		`{"l": [${x?}, 1]}`:  `{"l": [null, 1]}`,
+	}
+	for tmpl, want := range tests {
+		got, err := r.RenderJSON(tmpl)
+		if err != nil {
+			t.Errorf("RenderJSON(%q): %v", tmpl, err)
+			continue
+		}
+		if got != want {
+			t.Errorf("RenderJSON(%q) = %s, want %s", tmpl, got, want)
+		}
+	}
+
+	// Outside JSON both stay text
+	for tmpl, want := range map[string]string{"${x:-5}": "5", "[${x?}]": "[]"} {
+		if got, err := r.Render(tmpl); err != nil || got != want {
+			t.Errorf("Render(%q) = %q, %v, want %q", tmpl, got, err, want)
+		}
+	}
+}
+
+func TestRenderJSONTreeFallbacksAndOptionals(t *testing.T) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte("limit: ${x:-5}\nname: ${x:-abc}\nnote: ${x?}\n"), &doc); err != nil {
+		t.Fatal(err)
+	}
+	got, err := NewRenderer(map[string]interface{}{}).RenderJSONTree(&doc)
+	if err != nil {
+		t.Fatalf("RenderJSONTree: %v", err)
+	}
+	// An unset optional field is left out of a body built from YAML
+	if want := `{"limit":5,"name":"abc"}`; got != want {
+		t.Errorf("RenderJSONTree = %s, want %s", got, want)
+	}
+}
+
+func TestRenderContexts(t *testing.T) {
+	r := NewRenderer(map[string]interface{}{
+		"base_url": "https://api.test/v1",
+		"name":     `Ann "A" <x>&y`,
+		"id":       7,
+		"active":   true,
+		"ratio":    json.Number("0.5"),
+		"tags":     []interface{}{"a", "b c"},
+		"filter":   map[string]interface{}{"q": "x"},
+	})
+
+	render := map[string]string{
+		"name=${name}":    `name=Ann "A" <x>&y`,
+		"${id}/${active}": "7/true",
+		"${ratio}":        "0.5",
+		"${tags}":         "a,b c",
+		"${filter}":       `{"q":"x"}`,
+		"no placeholders": "no placeholders",
+	}
+	for tmpl, want := range render {
+		if got, err := r.Render(tmpl); err != nil || got != want {
+			t.Errorf("Render(%q) = %q, %v, want %q", tmpl, got, err, want)
+		}
+	}
+
+	renderJSON := map[string]string{
+		`{"name": "${name}"}`:            `{"name": "Ann \"A\" <x>&y"}`,
+		`{"name": ${name}}`:              `{"name": "Ann \"A\" <x>&y"}`,
+		`{"id": ${id}, "on": ${active}}`: `{"id": 7, "on": true}`,
+		`{"label": "#${id}"}`:            `{"label": "#7"}`,
+		`{"ratio": ${ratio}}`:            `{"ratio": 0.5}`,
+		`{"tags": ${tags}}`:              `{"tags": ["a","b c"]}`,
+		`{"tags": "${tags}"}`:            `{"tags": "a,b c"}`,
+		`{"filter": ${filter}}`:          `{"filter": {"q":"x"}}`,
+		`{"filter": "${filter}"}`:        `{"filter": "{\"q\":\"x\"}"}`,
+		`{"k\"${id}": 1}`:                `{"k\"7": 1}`,
+	}
+	for tmpl, want := range renderJSON {
+		if got, err := r.RenderJSON(tmpl); err != nil || got != want {
+			t.Errorf("RenderJSON(%q) = %s, %v, want %s", tmpl, got, err, want)
+		}
+	}
+
+	renderURL := map[string]string{
+		"${base_url}/users/${id}":        "https://api.test/v1/users/7",
+		"${base_url}/users/${name}":      "https://api.test/v1/users/Ann%20%22A%22%20%3Cx%3E&y",
+		"${base_url}/users?name=${name}": "https://api.test/v1/users?name=Ann+%22A%22+%3Cx%3E%26y",
+		"${base_url}/items?tags=${tags}": "https://api.test/v1/items?tags=a%2Cb+c",
+		"https://api.test/a b/${id}":     "https://api.test/a b/7",
+	}
+	for tmpl, want := range renderURL {
+		if got, err := r.RenderURL(tmpl); err != nil || got != want {
+			t.Errorf("RenderURL(%q) = %q, %v, want %q", tmpl, got, err, want)
+		}
+	}
+}
+
+func TestRenderFunctionsAndFilters(t *testing.T) {
+	t.Setenv("APICLI_TEST_TOKEN", "t0k")
+	r := NewRenderer(map[string]interface{}{
+		"name":  "ann",
+		"empty": "",
+		"user":  map[string]interface{}{"id": 7},
+	})
+
+	tests := map[string]string{
+		`${base64("ann")}`:            "YW5u",
+		"${base64(name)}":             "YW5u",
+		"${name|base64}":              "YW5u",
+		"${sha256(name)}":             "49915e0d7d4b402e3017d010bc1c0e83cac6c797d6c16e66340fe3268693a6a1",
+		"${name|sha256|base64}":       "NDk5MTVlMGQ3ZDRiNDAyZTMwMTdkMDEwYmMxYzBlODNjYWM2Yzc5N2Q2YzE2ZTY2MzQwZmUzMjY4NjkzYTZhMQ==",
+		`${urlencode("a b&c")}`:       "a+b%26c",
+		`${env("APICLI_TEST_TOKEN")}`: "t0k",
+		"${user|json}":                `{"id":7}`,
+		"${empty|base64}":             "",
+		"${randInt(4, 4)}":            "4",
+		`${now("2006")}`:              time.Now().Format("2006"),
+	}
+	for tmpl, want := range tests {
+		if got, err := r.Render(tmpl); err != nil || got != want {
+			t.Errorf("Render(%q) = %q, %v, want %q", tmpl, got, err, want)
+		}
+	}
+
+	if got, err := r.Render("${uuid()}"); err != nil || len(got) != 36 {
+		t.Errorf("Render(uuid()) = %q, %v, want a UUID", got, err)
+	}
+	if got, err := r.Render(`${now("unix")}`); err != nil || len(got) < 10 {
+		t.Errorf(`Render(now("unix")) = %q, %v, want a Unix time`, got, err)
+	}
+
+	errors := map[string]string{
+		`${env("APICLI_TEST_UNSET")}`: "env: environment variable 'APICLI_TEST_UNSET' is not set",
+		"${randInt(5, 1)}":            "randInt: min 5 is greater than max 1",
+		`${randInt("a", 1)}`:          "randInt: arguments must be integers",
+		"${base64(missing)}":          "base64: parameter 'missing' not found",
+		"${missing|base64}":           "parameter 'missing' not found",
+	}
+	for tmpl, want := range errors {
+		if _, err := r.Render(tmpl); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("Render(%q) error = %v, want it to contain %q", tmpl, err, want)
+		}
+	}
+}
+
+func TestRenderFallbacks(t *testing.T) {
+	r := NewRenderer(map[string]interface{}{"set": "yes", "empty": ""})
+	r.SetVariables(map[string]string{"region": "eu", "set": "shadowed"})
+
+	tests := map[string]string{
+		"${set:-no}":        "yes",
+		"${empty:-no}":      "",
+		"${missing:-no}":    "no",
+		"${missing:-}":      "",
+		"${missing:- a b }": " a b ",
+		// A placeholder ends at the first closing brace
+		"${missing:-a}b}":    "ab}",
+		"${missing?}":        "",
+		"${missing|base64?}": "",
+		// Filters apply to the fallback as well
+		"${missing|base64:-x}": "eA==",
+		"${region}":            "eu",
+		"${set}":               "yes",
+	}
+	for tmpl, want := range tests {
+		if got, err := r.Render(tmpl); err != nil || got != want {
+			t.Errorf("Render(%q) = %q, %v, want %q", tmpl, got, err, want)
+		}
+	}
+
+	if _, err := r.Render("${missing}"); err == nil || err.Error() != "parameter 'missing' not found" {
+		t.Errorf("Render(${missing}) error = %v", err)
+	}
+}
+
+func TestRenderPaths(t *testing.T) {
+	r := NewRenderer(map[string]interface{}{
+		"user": map[string]interface{}{
+			"name":       "ann",
+			"first name": "Ann",
+			"roles":      []interface{}{"admin", map[string]interface{}{"id": json.Number("2")}},
+		},
+		"raw":   json.RawMessage(`{"items": [{"id": 1}, {"id": 2}]}`),
+		"bad":   json.RawMessage(`{`),
+		"count": 3,
+	})
+	r.SetCaptured(map[string]interface{}{"token": "abc"})
+
+	tests := map[string]string{
+		"${user.name}":           "ann",
+		`${user["first name"]}`:  "Ann",
+		"${user.roles[0]}":       "admin",
+		"${user.roles[1].id}":    "2",
+		"${user.roles[ 1 ].id}":  "2",
+		"${raw.items[1].id}":     "2",
+		"${user.missing?}":       "",
+		"${user.roles[5]:-none}": "none",
+		"${vars.token}":          "abc",
+		"${vars.other?}":         "",
+	}
+	for tmpl, want := range tests {
+		if got, err := r.Render(tmpl); err != nil || got != want {
+			t.Errorf("Render(%q) = %q, %v, want %q", tmpl, got, err, want)
+		}
+	}
+
+	errors := map[string]string{
+		"${user.missing}":         "parameter 'user.missing' not found",
+		"${user[0]}":              "'user' is an object, not an array",
+		"${user.roles.x}":         "'user.roles' is an array, not an object",
+		"${count.x}":              "'count' is a number, not an object or array",
+		"${user.name.x}":          "'user.name' is a string, not an object or array",
+		"${bad.x}":                "'bad' is not valid JSON",
+		"${vars.other}":           "variable 'other' has not been captured or has expired",
+		"${credentials.password}": "credential 'password' is not available",
+	}
+	for tmpl, want := range errors {
+		if _, err := r.Render(tmpl); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("Render(%q) error = %v, want it to contain %q", tmpl, err, want)
+		}
+	}
+
+	r.SetCredentials(map[string]string{"user": "ann"})
+	if got, err := r.Render("${credentials.user}"); err != nil || got != "ann" {
+		t.Errorf("Render(${credentials.user}) = %q, %v", got, err)
+	}
+	if _, err := r.Render("${credentials.password}"); err == nil || !strings.Contains(err.Error(), "was not returned by the credential helper") {
+		t.Errorf("Render(${credentials.password}) error = %v", err)
+	}
+}
+
+func TestRenderMasksSensitiveResults(t *testing.T) {
+	var masked []string
+	r := NewRenderer(map[string]interface{}{"name": "ann"})
+	r.SetMasker(func(value string) { masked = append(masked, value) })
+	r.SetFunc("secret", func(args []interface{}) (interface{}, error) {
+		return "s3cr3t-" + args[0].(string), nil
+	})
+
+	got, err := r.Render(`${name}:${base64(secret("pw"))}`)
+	if err != nil {
+		t.Fatalf("Render: %v", err)
+	}
+	if got != "ann:czNjcjN0LXB3" {
+		t.Errorf("Render = %q", got)
+	}
+	// The secret and everything computed from it are masked, the name is not
+	if want := []string{"s3cr3t-pw", "czNjcjN0LXB3"}; !reflect.DeepEqual(masked, want) {
+		t.Errorf("masked %q, want %q", masked, want)
+	}
+}
+
+func TestRenderMasksEnvValues(t *testing.T) {
+	t.Setenv("APICLI_TEST_TOKEN", "tok-123")
+	var masked []string
+	r := NewRenderer(nil)
+	r.SetMasker(func(value string) { masked = append(masked, value) })
+
+	got, err := r.Render(`Bearer ${env("APICLI_TEST_TOKEN")}`)
+	if err != nil {
+		t.Fatalf("Render: %v", err)
+	}
+	if got != "Bearer tok-123" {
+		t.Errorf("Render = %q", got)
+	}
+	if want := []string{"tok-123"}; !reflect.DeepEqual(masked, want) {
+		t.Errorf("masked %q, want %q", masked, want)
+	}
+}
+
+func TestSetFuncIsPerRenderer(t *testing.T) {
+	a, b := NewRenderer(nil), NewRenderer(nil)
+	a.SetFunc("secret", func([]interface{}) (interface{}, error) { return "from a", nil })
+
+	if got, err := a.Render(`${secret("x")}`); err != nil || got != "from a" {
+		t.Errorf("a: Render = %q, %v", got, err)
+	}
+	// Another renderer does not see it
+	_, err := b.Render(`${secret("x")}`)
+	if err == nil || err.Error() != `evaluating '${secret("x")}': secret: not available in this command` {
+		t.Errorf("b: Render error = %v", err)
+	}
+}
@@ -0,0 +1,155 @@
+package template
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// RenderJSONTree builds a JSON document from a YAML tree whose leaves may
+// reference parameters. A leaf that is exactly one reference (`${name}`) is
+// replaced by the parameter's native JSON value, and is omitted together
+// with its key when the parameter was not supplied. Leaves mixing text and
+// references render to strings. Key order follows the YAML document.
+func (r *Renderer) RenderJSONTree(node *yaml.Node) (string, error) {
+	var out strings.Builder
+	omitted, err := r.writeJSONNode(&out, node)
+	if err != nil {
+		return "", err
+	}
+	if omitted {
+		return "null", nil
+	}
+	return out.String(), nil
+}
+
+// writeJSONNode writes node as JSON and reports whether it was omitted
+// because it references a parameter that was not supplied
+func (r *Renderer) writeJSONNode(out *strings.Builder, node *yaml.Node) (bool, error) {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		if len(node.Content) == 0 {
+			out.WriteString("null")
+			return false, nil
+		}
+		return r.writeJSONNode(out, node.Content[0])
+
+	case yaml.AliasNode:
+		return r.writeJSONNode(out, node.Alias)
+
+	case yaml.MappingNode:
+		out.WriteString("{")
+		first := true
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, err := r.Render(node.Content[i].Value)
+			if err != nil {
+				return false, fmt.Errorf("line %d: rendering key: %w", node.Content[i].Line, err)
+			}
+
+			var value strings.Builder
+			omitted, err := r.writeJSONNode(&value, node.Content[i+1])
+			if err != nil {
+				return false, err
+			}
+			if omitted {
+				continue
+			}
+
+			if !first {
+				out.WriteString(",")
+			}
+			first = false
+
+			encodedKey, err := marshalJSON(key)
+			if err != nil {
+				return false, err
+			}
+			out.WriteString(encodedKey)
+			out.WriteString(":")
+			out.WriteString(value.String())
+		}
+		out.WriteString("}")
+		return false, nil
+
+	case yaml.SequenceNode:
+		out.WriteString("[")
+		first := true
+		for _, child := range node.Content {
+			var value strings.Builder
+			omitted, err := r.writeJSONNode(&value, child)
+			if err != nil {
+				return false, err
+			}
+			if omitted {
+				continue
+			}
+			if !first {
+				out.WriteString(",")
+			}
+			first = false
+			out.WriteString(value.String())
+		}
+		out.WriteString("]")
+		return false, nil
+
+	case yaml.ScalarNode:
+		return r.writeJSONScalar(out, node)
+	}
+
+	return false, fmt.Errorf("line %d: unsupported YAML node", node.Line)
+}
+
+// writeJSONScalar writes a single YAML scalar as JSON
+func (r *Renderer) writeJSONScalar(out *strings.Builder, node *yaml.Node) (bool, error) {
+	t, err := r.compile(node.Value)
+	if err != nil {
+		return false, fmt.Errorf("line %d: %w", node.Line, err)
+	}
+
+	// A lone expression keeps its value's type, or disappears if unset
+	if t.IsLoneExpression() {
+		seg := t.segments[0]
+		value, ok, err := r.evaluate(seg.expr)
+		if err != nil {
+			return false, withPosition(seg.pos, fmt.Errorf("evaluating '${%s}': %w", seg.text, err))
+		}
+		if !ok {
+			return true, nil
+		}
+		encoded, err := marshalJSON(value)
+		if err != nil {
+			return false, withPosition(seg.pos, fmt.Errorf("encoding '${%s}': %w", seg.text, err))
+		}
+		out.WriteString(encoded)
+		return false, nil
+	}
+
+	// Text mixed with references renders to a string
+	if len(t.segments) > 1 {
+		rendered, err := r.render(t, func(_ int, value interface{}) (string, error) {
+			return r.convertToString(value)
+		})
+		if err != nil {
+			return false, err
+		}
+		encoded, err := marshalJSON(rendered)
+		if err != nil {
+			return false, err
+		}
+		out.WriteString(encoded)
+		return false, nil
+	}
+
+	// Plain literals keep their YAML type
+	var value interface{}
+	if err := node.Decode(&value); err != nil {
+		return false, fmt.Errorf("line %d: %w", node.Line, err)
+	}
+	encoded, err := marshalJSON(value)
+	if err != nil {
+		return false, fmt.Errorf("line %d: %w", node.Line, err)
+	}
+	out.WriteString(encoded)
+	return false, nil
+}
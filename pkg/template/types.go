@@ -0,0 +1,413 @@
+package template
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// paramType defines a parameter type: how a flag value is parsed, how an
+// element of a JSON array is converted, which format settings it accepts and
+// how it is described in help. How a value renders follows from the Go type
+// parse returns: strings, numbers and booleans render as themselves, and
+// Date, Duration and File render through their String and MarshalJSON
+// methods.
+type paramType struct {
+	parse       func(value, format string) (interface{}, error)
+	fromJSON    func(item interface{}, format string) (interface{}, error)
+	checkFormat func(format string) error
+	describe    func(format string) string
+}
+
+// paramTypes lists every supported type except array[T], which is built
+// from the type of its elements
+var paramTypes = map[string]paramType{
+	"string": {
+		parse:    func(value, _ string) (interface{}, error) { return value, nil },
+		fromJSON: fromJSONString("string", func(value, _ string) (interface{}, error) { return value, nil }),
+		describe: describeAs("string"),
+	},
+	"integer": {
+		parse: func(value, _ string) (interface{}, error) {
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, fmt.Errorf("value must be an integer")
+			}
+			return n, nil
+		},
+		fromJSON: func(item interface{}, _ string) (interface{}, error) {
+			number, ok := item.(json.Number)
+			if !ok {
+				return nil, fmt.Errorf("value must be an integer")
+			}
+			n, err := strconv.Atoi(number.String())
+			if err != nil {
+				return nil, fmt.Errorf("value must be an integer")
+			}
+			return n, nil
+		},
+		describe: describeAs("integer"),
+	},
+	"number": {
+		parse: func(value, _ string) (interface{}, error) {
+			if _, err := strconv.ParseFloat(value, 64); err != nil {
+				return nil, fmt.Errorf("value must be a number")
+			}
+			return json.Number(value), nil
+		},
+		fromJSON: func(item interface{}, _ string) (interface{}, error) {
+			if _, ok := item.(json.Number); !ok {
+				return nil, fmt.Errorf("value must be a number")
+			}
+			return item, nil
+		},
+		describe: describeAs("number"),
+	},
+	"boolean": {
+		parse: func(value, _ string) (interface{}, error) {
+			if value != "true" && value != "false" {
+				return nil, fmt.Errorf("value must be a boolean")
+			}
+			return value == "true", nil
+		},
+		fromJSON: func(item interface{}, _ string) (interface{}, error) {
+			if _, ok := item.(bool); !ok {
+				return nil, fmt.Errorf("value must be a boolean")
+			}
+			return item, nil
+		},
+		describe: describeAs("boolean (true|false)"),
+	},
+	"object": {
+		parse: func(value, _ string) (interface{}, error) {
+			var object map[string]interface{}
+			if err := decodeJSON(value, &object); err != nil || object == nil {
+				return nil, fmt.Errorf("value must be a JSON object")
+			}
+			return object, nil
+		},
+		fromJSON: func(item interface{}, _ string) (interface{}, error) {
+			if _, ok := item.(map[string]interface{}); !ok {
+				return nil, fmt.Errorf("value must be an object")
+			}
+			return item, nil
+		},
+		describe: describeAs("object (JSON)"),
+	},
+	"json": {
+		parse: func(value, _ string) (interface{}, error) {
+			var decoded interface{}
+			if err := decodeJSON(value, &decoded); err != nil {
+				return nil, fmt.Errorf("value must be valid JSON: %w", err)
+			}
+			return json.RawMessage(strings.TrimSpace(value)), nil
+		},
+		fromJSON: func(item interface{}, _ string) (interface{}, error) {
+			encoded, err := marshalJSON(item)
+			if err != nil {
+				return nil, err
+			}
+			return json.RawMessage(encoded), nil
+		},
+		describe: describeAs("json (any JSON value)"),
+	},
+	"date": {
+		parse:       parseDate("2006-01-02"),
+		fromJSON:    fromJSONString("date", parseDate("2006-01-02")),
+		checkFormat: checkTimeLayout,
+		describe: func(format string) string {
+			return "date (" + dateLayout(format, "2006-01-02") + ")"
+		},
+	},
+	"date-time": {
+		parse:       parseDate(time.RFC3339),
+		fromJSON:    fromJSONString("date-time", parseDate(time.RFC3339)),
+		checkFormat: checkTimeLayout,
+		describe: func(format string) string {
+			return "date-time (" + dateLayout(format, time.RFC3339) + ")"
+		},
+	},
+	"duration": {
+		parse:       parseDuration,
+		fromJSON:    fromJSONString("duration", parseDuration),
+		checkFormat: checkDurationUnit,
+		describe: func(format string) string {
+			if format == "" {
+				return "duration (e.g. 1m30s)"
+			}
+			return "duration (e.g. 1m30s, sent in " + format + ")"
+		},
+	},
+	"file": {
+		parse:    parseFile,
+		fromJSON: fromJSONString("file", parseFile),
+		describe: describeAs("file (path to an existing file)"),
+	},
+}
+
+// describeAs returns a describe function with a fixed text
+func describeAs(text string) func(string) string {
+	return func(string) string { return text }
+}
+
+// fromJSONString converts JSON array elements of a type whose values are
+// written as strings
+func fromJSONString(name string, parse func(value, format string) (interface{}, error)) func(interface{}, string) (interface{}, error) {
+	return func(item interface{}, format string) (interface{}, error) {
+		str, ok := item.(string)
+		if !ok {
+			return nil, fmt.Errorf("value must be a %s string", name)
+		}
+		return parse(str, format)
+	}
+}
+
+// lookupType resolves a type name, including array[T] types
+func lookupType(typeName string) (paramType, bool) {
+	if typeName == "" {
+		typeName = "string"
+	}
+	if !strings.HasPrefix(typeName, "array[") || !strings.HasSuffix(typeName, "]") {
+		t, ok := paramTypes[typeName]
+		return t, ok
+	}
+
+	elemName := strings.TrimSuffix(strings.TrimPrefix(typeName, "array["), "]")
+	elem, ok := lookupType(elemName)
+	if !ok {
+		return paramType{}, false
+	}
+	fromJSON := func(item interface{}, format string) (interface{}, error) {
+		items, ok := item.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("value must be an array")
+		}
+		for i, element := range items {
+			converted, err := elem.fromJSON(element, format)
+			if err != nil {
+				return nil, fmt.Errorf("element %d: %w", i, err)
+			}
+			items[i] = converted
+		}
+		return items, nil
+	}
+	return paramType{
+		// Arrays are written as JSON, or as a comma-separated list when the
+		// elements are plain values
+		parse: func(value, format string) (interface{}, error) {
+			if strings.HasPrefix(strings.TrimSpace(value), "[") {
+				var items []interface{}
+				if err := decodeJSON(value, &items); err != nil {
+					return nil, fmt.Errorf("value must be a JSON array: %w", err)
+				}
+				return fromJSON(items, format)
+			}
+			if strings.HasPrefix(elemName, "array[") || elemName == "object" || elemName == "json" {
+				return nil, fmt.Errorf("value must be a JSON array")
+			}
+			var items []interface{}
+			for i, part := range strings.Split(value, ",") {
+				converted, err := elem.parse(strings.TrimSpace(part), format)
+				if err != nil {
+					return nil, fmt.Errorf("element %d: %w", i, err)
+				}
+				items = append(items, converted)
+			}
+			return items, nil
+		},
+		fromJSON:    fromJSON,
+		checkFormat: elem.checkFormat,
+		describe: func(format string) string {
+			return "array of " + elem.describe(format)
+		},
+	}, true
+}
+
+// IsKnownType reports whether typeName is a supported parameter type
+func IsKnownType(typeName string) bool {
+	_, ok := lookupType(typeName)
+	return ok
+}
+
+// CheckFormat reports whether format is a valid format setting for the type
+func CheckFormat(typeName, format string) error {
+	t, ok := lookupType(typeName)
+	if !ok || format == "" {
+		return nil
+	}
+	if t.checkFormat == nil {
+		return fmt.Errorf("type '%s' does not take a format", typeName)
+	}
+	return t.checkFormat(format)
+}
+
+// DescribeType returns the help text for a type, such as "date (2006-01-02)"
+func DescribeType(typeName, format string) string {
+	t, ok := lookupType(typeName)
+	if !ok {
+		return typeName
+	}
+	return t.describe(format)
+}
+
+// ValidateType validates if a value matches the expected type
+func ValidateType(value, typeName, format string) error {
+	_, err := ParseValue(value, typeName, format)
+	return err
+}
+
+// ParseValue converts a flag value to the Go value of the given type, using
+// the parameter's format setting where the type has one
+func ParseValue(value, typeName, format string) (interface{}, error) {
+	t, ok := lookupType(typeName)
+	if !ok {
+		return nil, fmt.Errorf("unknown type '%s'", typeName)
+	}
+	return t.parse(value, format)
+}
+
+// Date is a date or date-time value, rendered in the parameter's format
+type Date struct {
+	Time   time.Time
+	Layout string
+}
+
+// String formats the date in its layout
+func (d Date) String() string {
+	return d.Time.Format(d.Layout)
+}
+
+// MarshalJSON encodes the date as a JSON string in its layout
+func (d Date) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// dateLayout resolves a date format setting, which is a name accepted by
+// now() or a Go layout
+func dateLayout(format, fallback string) string {
+	if format == "" {
+		return fallback
+	}
+	if named, ok := timeLayouts[format]; ok {
+		return named
+	}
+	return format
+}
+
+// parseDate returns a parse function for dates in the given default layout
+func parseDate(fallback string) func(value, format string) (interface{}, error) {
+	return func(value, format string) (interface{}, error) {
+		layout := dateLayout(format, fallback)
+		parsed, err := time.Parse(layout, value)
+		if err != nil {
+			return nil, fmt.Errorf("value must be a date in the format %s", layout)
+		}
+		return Date{Time: parsed, Layout: layout}, nil
+	}
+}
+
+// checkTimeLayout rejects layouts without any date or time element and
+// layouts that cannot parse what they format
+func checkTimeLayout(format string) error {
+	layout := dateLayout(format, "")
+	reference := time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC)
+	if _, err := time.Parse(layout, reference.Format(layout)); err != nil || layout == reference.Format(layout) {
+		return fmt.Errorf("invalid date format '%s'", format)
+	}
+	return nil
+}
+
+// durationUnits maps the units a duration can be sent in to their size
+var durationUnits = map[string]time.Duration{
+	"":             0,
+	"seconds":      time.Second,
+	"milliseconds": time.Millisecond,
+}
+
+// Duration is a duration value, rendered as Go duration text (1m30s) or,
+// when its parameter sets a unit format, as a number of that unit
+type Duration struct {
+	Duration time.Duration
+	Unit     string
+}
+
+// String formats the duration as text or as a number of its unit
+func (d Duration) String() string {
+	if unit := durationUnits[d.Unit]; unit != 0 {
+		return strconv.FormatFloat(float64(d.Duration)/float64(unit), 'f', -1, 64)
+	}
+	return d.Duration.String()
+}
+
+// MarshalJSON encodes the duration as a number of its unit, or as a string
+func (d Duration) MarshalJSON() ([]byte, error) {
+	if durationUnits[d.Unit] != 0 {
+		return []byte(d.String()), nil
+	}
+	return json.Marshal(d.String())
+}
+
+func parseDuration(value, format string) (interface{}, error) {
+	// A bare number is taken in the parameter's unit, if it has one
+	if unit := durationUnits[format]; unit != 0 {
+		if n, err := strconv.ParseFloat(value, 64); err == nil {
+			return Duration{Duration: time.Duration(n * float64(unit)), Unit: format}, nil
+		}
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return nil, fmt.Errorf("value must be a duration such as 30s or 1h30m")
+	}
+	return Duration{Duration: parsed, Unit: format}, nil
+}
+
+func checkDurationUnit(format string) error {
+	if _, ok := durationUnits[format]; !ok {
+		return fmt.Errorf("invalid duration format '%s' (expected seconds or milliseconds)", format)
+	}
+	return nil
+}
+
+// File is the path of an existing file. It renders as its path; request
+// bodies and form fields that consist of a file parameter stream its
+// contents instead.
+type File struct {
+	Path string
+}
+
+// String returns the file's path
+func (f File) String() string {
+	return f.Path
+}
+
+// MarshalJSON encodes the file's path as a JSON string
+func (f File) MarshalJSON() ([]byte, error) {
+	return json.Marshal(f.Path)
+}
+
+func parseFile(value, _ string) (interface{}, error) {
+	info, err := os.Stat(value)
+	if err != nil {
+		return nil, fmt.Errorf("value must be an existing file: %w", err)
+	}
+	if info.IsDir() {
+		return nil, fmt.Errorf("value must be a file, not a directory")
+	}
+	return File{Path: value}, nil
+}
+
+// decodeJSON decodes a single JSON value, keeping numbers exact
+func decodeJSON(data string, v interface{}) error {
+	decoder := json.NewDecoder(strings.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(v); err != nil {
+		return err
+	}
+	if decoder.More() {
+		return fmt.Errorf("unexpected data after the JSON value")
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+package template
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseValue(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "body.json")
+	if err := os.WriteFile(file, []byte("{}"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// Each value is parsed, then rendered as text and as a JSON value
+	tests := []struct {
+		value, typeName, format string
+		text, json              string
+	}{
+		{"ann", "", "", "ann", `"ann"`},
+		{"ann", "string", "", "ann", `"ann"`},
+		{"42", "integer", "", "42", "42"},
+		{"-1.50", "number", "", "-1.50", "-1.50"},
+		{"true", "boolean", "", "true", "true"},
+		{`{"a": [1, 2.0]}`, "object", "", `{"a":[1,2.0]}`, `{"a":[1,2.0]}`},
+		{` [1, "x"] `, "json", "", `[1, "x"]`, `[1,"x"]`},
+		{"null", "json", "", "null", "null"},
+		{"1, 2,3", "array[integer]", "", "1,2,3", "[1,2,3]"},
+		{"[1, 2]", "array[integer]", "", "1,2", "[1,2]"},
+		{`[[1], [2]]`, "array[array[integer]]", "", "[[1],[2]]", "[[1],[2]]"},
+		{`[{"a": 1}]`, "array[object]", "", `[{"a":1}]`, `[{"a":1}]`},
+		{"2024-02-29", "date", "", "2024-02-29", `"2024-02-29"`},
+		{"29.02.2024", "date", "02.01.2006", "29.02.2024", `"29.02.2024"`},
+		{"2024-02-29T10:00:00Z", "date-time", "", "2024-02-29T10:00:00Z", `"2024-02-29T10:00:00Z"`},
+		{"2024-02-29", "array[date]", "date", "2024-02-29", `["2024-02-29"]`},
+		{"1m30s", "duration", "", "1m30s", `"1m30s"`},
+		{"1m30s", "duration", "seconds", "90", "90"},
+		{"1.5", "duration", "seconds", "1.5", "1.5"},
+		{"2s", "duration", "milliseconds", "2000", "2000"},
+		{file, "file", "", file, `"` + file + `"`},
+	}
+	r := NewRenderer(nil)
+	for _, tt := range tests {
+		value, err := ParseValue(tt.value, tt.typeName, tt.format)
+		if err != nil {
+			t.Errorf("ParseValue(%q, %s): %v", tt.value, tt.typeName, err)
+			continue
+		}
+		if text, err := r.convertToString(value); err != nil || text != tt.text {
+			t.Errorf("ParseValue(%q, %s) renders as %q, %v, want %q", tt.value, tt.typeName, text, err, tt.text)
+		}
+		if encoded, err := marshalJSON(value); err != nil || encoded != tt.json {
+			t.Errorf("ParseValue(%q, %s) encodes as %s, %v, want %s", tt.value, tt.typeName, encoded, err, tt.json)
+		}
+	}
+}
+
+func TestParseValueErrors(t *testing.T) {
+	tests := []struct {
+		value, typeName, format string
+		want                    string
+	}{
+		{"4.2", "integer", "", "value must be an integer"},
+		{"1e", "number", "", "value must be a number"},
+		{"yes", "boolean", "", "value must be a boolean"},
+		{"[1]", "object", "", "value must be a JSON object"},
+		{"null", "object", "", "value must be a JSON object"},
+		{"{} {}", "json", "", "unexpected data after the JSON value"},
+		{"1,x", "array[integer]", "", "element 1: value must be an integer"},
+		{`[1, "2"]`, "array[integer]", "", "element 1: value must be an integer"},
+		{`{"a": 1}`, "array[object]", "", "value must be a JSON array"},
+		{`[["x"]]`, "array[array[integer]]", "", "element 0: element 0: value must be an integer"},
+		{`[1]`, "array[date]", "", "element 0: value must be a date string"},
+		{"2024-02-30", "date", "", "value must be a date in the format 2006-01-02"},
+		{"90", "duration", "", "value must be a duration"},
+		{"/nonexistent/file", "file", "", "value must be an existing file"},
+		{os.TempDir(), "file", "", "value must be a file, not a directory"},
+		{"x", "uuid", "", "unknown type 'uuid'"},
+		{"x", "array[uuid]", "", "unknown type 'array[uuid]'"},
+	}
+	for _, tt := range tests {
+		_, err := ParseValue(tt.value, tt.typeName, tt.format)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("ParseValue(%q, %s) error = %v, want it to contain %q", tt.value, tt.typeName, err, tt.want)
+		}
+	}
+}
+
+func TestCheckFormat(t *testing.T) {
+	valid := [][2]string{
+		{"date", "RFC3339"},
+		{"date", "02.01.2006"},
+		{"date-time", "RFC3339Nano"},
+		{"array[date]", "date"},
+		{"duration", "seconds"},
+		{"string", ""},
+	}
+	for _, tt := range valid {
+		if err := CheckFormat(tt[0], tt[1]); err != nil {
+			t.Errorf("CheckFormat(%s, %q): %v", tt[0], tt[1], err)
+		}
+	}
+
+	invalid := map[[2]string]string{
+		{"date", "yyyy-mm-dd"}: "invalid date format 'yyyy-mm-dd'",
+		{"duration", "hours"}:  "invalid duration format 'hours' (expected seconds or milliseconds)",
+		{"integer", "int64"}:   "type 'integer' does not take a format",
+		{"array[string]", "x"}: "type 'array[string]' does not take a format",
+	}
+	for tt, want := range invalid {
+		if err := CheckFormat(tt[0], tt[1]); err == nil || err.Error() != want {
+			t.Errorf("CheckFormat(%s, %q) error = %v, want %q", tt[0], tt[1], err, want)
+		}
+	}
+}
+
+func TestDescribeType(t *testing.T) {
+	tests := map[[2]string]string{
+		{"", ""}:                      "string",
+		{"boolean", ""}:               "boolean (true|false)",
+		{"date", ""}:                  "date (2006-01-02)",
+		{"date", "RFC1123"}:           "date (Mon, 02 Jan 2006 15:04:05 MST)",
+		{"duration", "seconds"}:       "duration (e.g. 1m30s, sent in seconds)",
+		{"array[array[integer]]", ""}: "array of array of integer",
+		{"uuid", ""}:                  "uuid",
+	}
+	for tt, want := range tests {
+		if got := DescribeType(tt[0], tt[1]); got != want {
+			t.Errorf("DescribeType(%s, %q) = %q, want %q", tt[0], tt[1], got, want)
+		}
+	}
+}
+
+func TestArrayElementsKeepTheirType(t *testing.T) {
+	value, err := ParseValue(`["1s", "2m"]`, "array[duration]", "seconds")
+	if err != nil {
+		t.Fatalf("ParseValue: %v", err)
+	}
+	body, err := NewRenderer(map[string]interface{}{"waits": value}).RenderJSON(`{"waits": ${waits}}`)
+	if err != nil {
+		t.Fatalf("RenderJSON: %v", err)
+	}
+	if want := `{"waits": [1,120]}`; body != want {
+		t.Errorf("RenderJSON = %s, want %s", body, want)
+	}
+	if !reflect.DeepEqual(value.([]interface{})[0], Duration{Duration: 1e9, Unit: "seconds"}) {
+		t.Errorf("element 0 = %#v", value.([]interface{})[0])
+	}
+}
@@ -0,0 +1,104 @@
+// Package term holds helpers for working with the user's terminal
+package term
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// hidden is set while ReadHidden has the terminal's echo turned off
+var (
+	hiddenMu sync.Mutex
+	hidden   bool
+)
+
+// IsTerminal reports whether f is a terminal rather than a file or pipe
+func IsTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// Open opens the controlling terminal, which stays available for prompts
+// when stdin carries a request body or parameter value
+func Open() (*os.File, error) {
+	return os.OpenFile("/dev/tty", os.O_RDWR, 0)
+}
+
+// ReadHidden prompts on the terminal and reads a line without echoing it.
+// Cancelling ctx abandons the read, with echo back on.
+func ReadHidden(ctx context.Context, tty *os.File, prompt string) (string, error) {
+	fmt.Fprint(tty, prompt)
+	if err := stty("-echo"); err == nil {
+		hiddenMu.Lock()
+		hidden = true
+		hiddenMu.Unlock()
+		defer func() {
+			Restore()
+			fmt.Fprintln(tty)
+		}()
+	}
+
+	// A deadline in the past wakes the read below when ctx is cancelled
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			tty.SetReadDeadline(time.Now())
+		case <-done:
+		}
+	}()
+
+	// Read a byte at a time so input typed ahead for a later prompt is kept
+	var line []byte
+	buf := make([]byte, 1)
+	for {
+		n, err := tty.Read(buf)
+		if n == 1 {
+			if buf[0] == '\n' {
+				break
+			}
+			line = append(line, buf[0])
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return "", fmt.Errorf("prompt cancelled")
+			}
+			if len(line) == 0 {
+				return "", fmt.Errorf("reading from terminal: %w", err)
+			}
+			break
+		}
+	}
+	return strings.TrimRight(string(line), "\r"), nil
+}
+
+// Restore turns echo back on if a hidden read left it off. main calls it
+// before exiting on a second Ctrl-C, which does not wait for the read.
+func Restore() {
+	hiddenMu.Lock()
+	defer hiddenMu.Unlock()
+	if hidden {
+		stty("echo")
+		hidden = false
+	}
+}
+
+// stty changes a setting of the controlling terminal. It opens a terminal of
+// its own: handing a file to a command puts it in blocking mode, after which
+// a read deadline no longer interrupts ReadHidden.
+func stty(setting string) error {
+	tty, err := Open()
+	if err != nil {
+		return err
+	}
+	defer tty.Close()
+	cmd := exec.Command("stty", setting)
+	cmd.Stdin = tty
+	return cmd.Run()
+}
@@ -0,0 +1,123 @@
+package vars
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// pathStep is a field or element access in a JSONPath expression
+type pathStep struct {
+	key     string
+	index   int
+	isIndex bool
+}
+
+// String formats the step the way it is written in a path
+func (s pathStep) String() string {
+	if s.isIndex {
+		return fmt.Sprintf("[%d]", s.index)
+	}
+	return "." + s.key
+}
+
+// CheckPath reports syntax errors in a JSONPath expression
+func CheckPath(path string) error {
+	_, err := parsePath(path)
+	return err
+}
+
+// parsePath parses a JSONPath expression made of field (.name or ['name'])
+// and element ([0]) accesses from the root $
+func parsePath(path string) ([]pathStep, error) {
+	if !strings.HasPrefix(path, "$") {
+		return nil, fmt.Errorf("path '%s' must start with '$'", path)
+	}
+
+	var steps []pathStep
+	rest := path[1:]
+	for rest != "" {
+		switch {
+		case strings.HasPrefix(rest, "['"):
+			end := strings.Index(rest[2:], "']")
+			if end < 0 {
+				return nil, fmt.Errorf("missing \"']\" in path '%s'", path)
+			}
+			steps = append(steps, pathStep{key: rest[2 : 2+end]})
+			rest = rest[2+end+2:]
+
+		case strings.HasPrefix(rest, "["):
+			end := strings.Index(rest, "]")
+			if end < 0 {
+				return nil, fmt.Errorf("missing ']' in path '%s'", path)
+			}
+			index, err := strconv.Atoi(rest[1:end])
+			if err != nil || index < 0 {
+				return nil, fmt.Errorf("invalid index '%s' in path '%s'", rest[1:end], path)
+			}
+			steps = append(steps, pathStep{index: index, isIndex: true})
+			rest = rest[end+1:]
+
+		case strings.HasPrefix(rest, "."):
+			end := strings.IndexAny(rest[1:], ".[")
+			if end < 0 {
+				end = len(rest) - 1
+			}
+			if end == 0 {
+				return nil, fmt.Errorf("missing field name in path '%s'", path)
+			}
+			steps = append(steps, pathStep{key: rest[1 : 1+end]})
+			rest = rest[1+end:]
+
+		default:
+			return nil, fmt.Errorf("unexpected '%s' in path '%s'", rest, path)
+		}
+	}
+	return steps, nil
+}
+
+// Lookup evaluates a JSONPath expression against a decoded JSON document.
+// Field names fall back to a case-insensitive match, so header names can
+// be written in any case.
+func Lookup(doc interface{}, path string) (interface{}, error) {
+	steps, err := parsePath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	value := doc
+	walked := "$"
+	for _, step := range steps {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			if step.isIndex {
+				return nil, fmt.Errorf("%s is an object, not an array", walked)
+			}
+			field, ok := v[step.key]
+			if !ok {
+				for key, candidate := range v {
+					if strings.EqualFold(key, step.key) {
+						field, ok = candidate, true
+						break
+					}
+				}
+			}
+			if !ok {
+				return nil, fmt.Errorf("%s has no field '%s'", walked, step.key)
+			}
+			value = field
+		case []interface{}:
+			if !step.isIndex {
+				return nil, fmt.Errorf("%s is an array, not an object", walked)
+			}
+			if step.index >= len(v) {
+				return nil, fmt.Errorf("%s has no element %d", walked, step.index)
+			}
+			value = v[step.index]
+		default:
+			return nil, fmt.Errorf("%s is not an object or array", walked)
+		}
+		walked += step.String()
+	}
+	return value, nil
+}
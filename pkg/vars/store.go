@@ -0,0 +1,142 @@
+package vars
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Variable is a value captured from an API response
+type Variable struct {
+	Value      interface{} `json:"value"`
+	Source     string      `json:"source,omitempty"`
+	Secret     bool        `json:"secret,omitempty"`
+	CapturedAt time.Time   `json:"captured_at"`
+	ExpiresAt  *time.Time  `json:"expires_at,omitempty"`
+}
+
+// Expired reports whether the variable's TTL has passed
+func (v Variable) Expired(now time.Time) bool {
+	return v.ExpiresAt != nil && !now.Before(*v.ExpiresAt)
+}
+
+// Store persists captured variables in vars.json in the apicli directory
+type Store struct {
+	path string
+}
+
+// NewStore creates a store for variables kept under baseDir
+func NewStore(baseDir string) *Store {
+	return &Store{path: filepath.Join(baseDir, "vars.json")}
+}
+
+// Load returns every variable that has not expired
+func (s *Store) Load() (map[string]Variable, error) {
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return make(map[string]Variable), nil
+		}
+		return nil, fmt.Errorf("reading variables file: %w", err)
+	}
+
+	// Numbers are kept exact, as they were captured
+	variables := make(map[string]Variable)
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&variables); err != nil {
+		return nil, fmt.Errorf("parsing variables file: %w", err)
+	}
+
+	now := time.Now()
+	for name, v := range variables {
+		if v.Expired(now) {
+			delete(variables, name)
+		}
+	}
+	return variables, nil
+}
+
+// Values returns the value of every variable that has not expired
+func (s *Store) Values() (map[string]interface{}, error) {
+	variables, err := s.Load()
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]interface{}, len(variables))
+	for name, v := range variables {
+		values[name] = v.Value
+	}
+	return values, nil
+}
+
+// Update sets the given variables, keeping the others
+func (s *Store) Update(updates map[string]Variable) error {
+	variables, err := s.Load()
+	if err != nil {
+		return err
+	}
+	for name, v := range updates {
+		variables[name] = v
+	}
+	return s.save(variables)
+}
+
+// Delete removes the named variables. If any of them does not exist,
+// nothing is removed and the missing names are returned.
+func (s *Store) Delete(names ...string) ([]string, error) {
+	variables, err := s.Load()
+	if err != nil {
+		return nil, err
+	}
+	var missing []string
+	for _, name := range names {
+		if _, ok := variables[name]; !ok {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return missing, nil
+	}
+	for _, name := range names {
+		delete(variables, name)
+	}
+	return nil, s.save(variables)
+}
+
+// Clear removes every variable
+func (s *Store) Clear() error {
+	return s.save(make(map[string]Variable))
+}
+
+func (s *Store) save(variables map[string]Variable) error {
+	data, err := json.MarshalIndent(variables, "", "  ")
+	if err != nil {
+		return fmt.Errorf("serializing variables: %w", err)
+	}
+
+	// Write a temporary file and rename it over the variables, so an
+	// interrupted write never leaves a truncated file behind. Captured values
+	// are often tokens, and CreateTemp keeps the file private to the user.
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), ".vars-*.json")
+	if err != nil {
+		return fmt.Errorf("writing variables file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("writing variables file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("writing variables file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("writing variables file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package vars
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStoreSave(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "vars.json")
+	// A file left readable by an earlier version is replaced by a private one
+	if err := os.WriteFile(path, []byte("{}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	store := NewStore(dir)
+	past := time.Now().Add(-time.Minute)
+	err := store.Update(map[string]Variable{
+		"token":   {Value: "abc", Secret: true, CapturedAt: time.Now()},
+		"count":   {Value: json.Number("12345678901234567890"), CapturedAt: time.Now()},
+		"expired": {Value: "old", CapturedAt: past, ExpiresAt: &past},
+	})
+	if err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+
+	values, err := store.Values()
+	if err != nil {
+		t.Fatalf("Values: %v", err)
+	}
+	if len(values) != 2 || values["token"] != "abc" || values["count"] != json.Number("12345678901234567890") {
+		t.Errorf("Values = %v", values)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("vars.json has mode %o, want 0600", info.Mode().Perm())
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("files in the directory: %v, want only vars.json", entries)
+	}
+}
+
+func TestStoreDelete(t *testing.T) {
+	store := NewStore(t.TempDir())
+	if err := store.Update(map[string]Variable{"a": {Value: "1"}, "b": {Value: "2"}}); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+
+	// Nothing is removed when a name is missing
+	missing, err := store.Delete("a", "z", "y")
+	if err != nil || len(missing) != 2 || missing[0] != "y" || missing[1] != "z" {
+		t.Errorf("Delete = %v, %v; want [y z]", missing, err)
+	}
+	if values, _ := store.Values(); len(values) != 2 {
+		t.Errorf("Values = %v, want both kept", values)
+	}
+
+	if missing, err := store.Delete("a"); err != nil || missing != nil {
+		t.Errorf("Delete(a) = %v, %v", missing, err)
+	}
+	if values, _ := store.Values(); len(values) != 1 || values["b"] != "2" {
+		t.Errorf("Values = %v, want only b", values)
+	}
+
+	if err := store.Clear(); err != nil {
+		t.Fatalf("Clear: %v", err)
+	}
+	if values, _ := store.Values(); len(values) != 0 {
+		t.Errorf("Values = %v after Clear", values)
+	}
+}